@@ -2,6 +2,7 @@ package logjam
 
 import (
 	"net/http"
+	"sort"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -82,6 +83,29 @@ func defaultActionNameParts(method, path string) []string {
 	return parts
 }
 
+// RouteTemplateActionName derives an action name from a route template like
+// /users/{id}/friends (gorilla/mux, chi) or /users/:id/friends (httprouter). Path
+// parameters are replaced by Id, so that the result matches the action name produced by
+// DefaultActionNameExtractor for paths with numeric ids.
+func RouteTemplateActionName(method, template string) string {
+	parts := []string{}
+	for _, part := range strings.Split(template, "/") {
+		switch {
+		case part == "":
+			continue
+		case strings.HasPrefix(part, "{") || strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*"):
+			parts = append(parts, "Id")
+		default:
+			parts = append(parts, formatSegment(part))
+		}
+	}
+	methodStr := strings.ToLower(method)
+	if len(parts) == 0 {
+		return "Unknown#" + methodStr
+	}
+	return strings.Join(parts, "::") + "#" + methodStr
+}
+
 func formatSegment(s string) string {
 	s = strings.Replace(s, "_", "-", -1)
 	parts := strings.Split(s, "-")
@@ -90,3 +114,109 @@ func formatSegment(s string) string {
 	}
 	return strings.Join(parts, "")
 }
+
+// PatternActionNameExtractor returns an extractor looking up action names for request
+// paths in a map from path patterns to action names, falling back to
+// DefaultActionNameExtractor for requests matching no pattern. Patterns may start with a
+// method, like "GET /users/{id}". Segments like "{id}", ":id" or "*" match any single
+// path segment, a last segment like "{path...}", "*path" or "**" matches the remaining
+// path. The most specific pattern wins if several patterns match. Action names without
+// "#" get the HTTP request method in lowercase appended.
+func PatternActionNameExtractor(patterns map[string]string) ActionNameExtractor {
+	compiled := make([]actionPattern, 0, len(patterns))
+	for pattern, action := range patterns {
+		compiled = append(compiled, compileActionPattern(pattern, action))
+	}
+	sort.Slice(compiled, func(i, j int) bool {
+		return compiled[i].moreSpecific(&compiled[j])
+	})
+	return func(r *http.Request) string {
+		segments := pathSegments(r.URL.Path)
+		for i := range compiled {
+			if p := &compiled[i]; p.matches(r.Method, segments) {
+				if p.appendMethod {
+					return p.action + "#" + strings.ToLower(r.Method)
+				}
+				return p.action
+			}
+		}
+		return DefaultActionNameExtractor(r)
+	}
+}
+
+// actionPattern is a compiled pattern of a PatternActionNameExtractor.
+type actionPattern struct {
+	pattern      string   // the original pattern
+	method       string   // the method to match, matching all methods if empty
+	segments     []string // path segments, with wildcards replaced by "*"
+	literals     int      // the number of literal segments
+	rest         bool     // whether the pattern matches any remaining path segments
+	action       string   // the action name
+	appendMethod bool     // whether the request method needs to be appended to the action
+}
+
+func compileActionPattern(pattern, action string) actionPattern {
+	p := actionPattern{pattern: pattern, action: action, appendMethod: !strings.Contains(action, "#")}
+	path := pattern
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		p.method = strings.ToUpper(pattern[:i])
+		path = strings.TrimSpace(pattern[i+1:])
+	}
+	p.segments = pathSegments(path)
+	if n := len(p.segments); n > 0 {
+		last := p.segments[n-1]
+		if last == "**" || strings.HasSuffix(last, "...}") || (strings.HasPrefix(last, "*") && len(last) > 1) {
+			p.rest = true
+			p.segments = p.segments[:n-1]
+		}
+	}
+	for i, segment := range p.segments {
+		if segment == "*" || strings.HasPrefix(segment, "{") || strings.HasPrefix(segment, ":") {
+			p.segments[i] = "*"
+		} else {
+			p.literals++
+		}
+	}
+	return p
+}
+
+func (p *actionPattern) matches(method string, segments []string) bool {
+	if p.method != "" && p.method != method {
+		return false
+	}
+	if len(segments) < len(p.segments) || (!p.rest && len(segments) != len(p.segments)) {
+		return false
+	}
+	for i, segment := range p.segments {
+		if segment != "*" && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// moreSpecific orders patterns by the number of literal segments, then prefers patterns
+// with a method, without a rest wildcard and with more segments.
+func (p *actionPattern) moreSpecific(o *actionPattern) bool {
+	switch {
+	case p.literals != o.literals:
+		return p.literals > o.literals
+	case (p.method != "") != (o.method != ""):
+		return p.method != ""
+	case p.rest != o.rest:
+		return !p.rest
+	case len(p.segments) != len(o.segments):
+		return len(p.segments) > len(o.segments)
+	}
+	return p.pattern < o.pattern
+}
+
+func pathSegments(path string) []string {
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
@@ -1,6 +1,7 @@
 package logjam
 
 import (
+	"net/http/httptest"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -93,3 +94,41 @@ func TestDefaultActionNameExtractor(t *testing.T) {
 		})
 	})
 }
+
+func TestRouteTemplateActionName(t *testing.T) {
+	Convey("RouteTemplateActionName", t, func() {
+		So(RouteTemplateActionName("GET", "/users/{user_id}/friends"), ShouldEqual, "Users::Id::Friends#get")
+		So(RouteTemplateActionName("PUT", "/users/:user_id"), ShouldEqual, "Users::Id#put")
+		So(RouteTemplateActionName("GET", "/static/*filepath"), ShouldEqual, "Static::Id#get")
+		So(RouteTemplateActionName("GET", "/user_groups/{id:[0-9]+}"), ShouldEqual, "UserGroups::Id#get")
+		So(RouteTemplateActionName("GET", "/"), ShouldEqual, "Unknown#get")
+	})
+}
+
+func TestPatternActionNameExtractor(t *testing.T) {
+	Convey("PatternActionNameExtractor", t, func() {
+		extract := PatternActionNameExtractor(map[string]string{
+			"/users":            "Users#index",
+			"GET /users/{id}":   "Users#show",
+			"/users/:id":        "Users",
+			"/users/me":         "Users#me",
+			"/users/*/friends":  "Users#friends",
+			"/static/{path...}": "Assets#show",
+			"/static/images/**": "Assets#image",
+			"/downloads/*file":  "Downloads#show",
+		})
+		actionName := func(method, path string) string {
+			return extract(httptest.NewRequest(method, path, nil))
+		}
+
+		So(actionName("GET", "/users"), ShouldEqual, "Users#index")
+		So(actionName("GET", "/users/123"), ShouldEqual, "Users#show")
+		So(actionName("PUT", "/users/123"), ShouldEqual, "Users#put")
+		So(actionName("GET", "/users/me"), ShouldEqual, "Users#me")
+		So(actionName("GET", "/users/123/friends"), ShouldEqual, "Users#friends")
+		So(actionName("GET", "/static/css/app.css"), ShouldEqual, "Assets#show")
+		So(actionName("GET", "/static/images/logo.png"), ShouldEqual, "Assets#image")
+		So(actionName("GET", "/downloads/a/b.zip"), ShouldEqual, "Downloads#show")
+		So(actionName("GET", "/groups/123"), ShouldEqual, "Groups::Id#get")
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"regexp"
@@ -20,6 +21,9 @@ import (
 const (
 	maxLineLengthDefault    = 2048
 	maxBytesAllLinesDefault = 1024 * 1024
+	maxFieldBytesDefault    = 64 * 1024
+	ipv4MaskBitsDefault     = 24
+	ipv6MaskBitsDefault     = 112
 )
 
 // Printer is a minimal interface for the agent to log errors.
@@ -36,36 +40,66 @@ func (d *DiscardingLogger) Println(v ...interface{}) {}
 // Agent encapsulates information about a logjam agent.
 type Agent struct {
 	Options
-	socket    *zmq.Socket // ZeroMQ DEALER socker
-	mutex     sync.Mutex  // ZeroMQ sockets are not thread safe
-	sequence  uint64      // sequence number for outgoing messages
-	endpoints []string    // Slice representation of opts.Endpoints with port and protocol added
-	stream    string      // The stream name to be used when sending messages
-	topic     string      // The default log topic
+	socket           *zmq.Socket      // ZeroMQ DEALER socker
+	mutex            sync.Mutex       // ZeroMQ sockets are not thread safe
+	sequence         uint64           // sequence number for outgoing messages
+	endpoints        []string         // Slice representation of opts.Endpoints with port and protocol added
+	stream           string           // The stream name to be used when sending messages
+	topic            string           // The default log topic
+	soft             []*regexp.Regexp // Compiled representation of opts.SoftExceptions
+	idFallbacks      uint64           // Number of request ids generated using math/rand
+	filteredRequests uint64           // Number of HEAD and bot requests not sent to logjam
+	inFlight         int64            // Number of requests created but not finished yet
+	lastStackDump    int64            // Unix time in nanoseconds of the last stack dump of a stuck request
+	filterParameters []string         // Lower case representation of opts.FilterParameters
+	internalNetworks []*net.IPNet     // Parsed representation of opts.InternalNetworks
+	attachedMutex    sync.Mutex       // Guards attached
+	attached         map[string]int64 // Counters added to the next request sent to logjam
 }
 
 // Options such as appliction name, environment and ZeroMQ socket options.
 type Options struct {
-	AppName             string              // Name of your application
-	EnvName             string              // What environment you're running in (production, preview, ...)
-	Endpoints           string              // Comma separated list of ZeroMQ connections specs, defaults to localhost
-	Port                int                 // ZeroMQ default port for ceonnection specs
-	Linger              int                 // ZeroMQ socket option of the same name
-	Sndhwm              int                 // ZeroMQ socket option of the same name
-	Rcvhwm              int                 // ZeroMQ socket option of the same name
-	Sndtimeo            int                 // ZeroMQ socket option of the same name
-	Rcvtimeo            int                 // ZeroMQ socket option of the same name
-	Logger              Printer             // Logjam errors are printed using this interface.
-	LogLevel            LogLevel            // Only lines with a severity equal to or higher are sent to logjam. Defaults to DEBUG.
-	ActionNameExtractor ActionNameExtractor // Function to transform path segments to logjam action names.
-	ObfuscateIPs        bool                // Whether IP addresses should be obfuscated.
-	MaxLineLength       int                 // Long lines truncation threshold, defaults to 2048.
-	MaxBytesAllLines    int                 // Max number of bytes of all log lines, defaults to 1MB.
+	AppName              string              // Name of your application
+	EnvName              string              // What environment you're running in (production, preview, ...)
+	Endpoints            string              // Comma separated list of ZeroMQ connections specs, defaults to localhost
+	Port                 int                 // ZeroMQ default port for ceonnection specs
+	Linger               int                 // ZeroMQ socket option of the same name
+	Sndhwm               int                 // ZeroMQ socket option of the same name
+	Rcvhwm               int                 // ZeroMQ socket option of the same name
+	Sndtimeo             int                 // ZeroMQ socket option of the same name
+	Rcvtimeo             int                 // ZeroMQ socket option of the same name
+	Logger               Printer             // Logjam errors are printed using this interface.
+	LogLevel             LogLevel            // Only lines with a severity equal to or higher are sent to logjam. Defaults to DEBUG.
+	ActionNameExtractor  ActionNameExtractor // Function to transform path segments to logjam action names.
+	ObfuscateIPs         bool                // Whether IP addresses should be obfuscated.
+	MaxLineLength        int                 // Long lines truncation threshold, defaults to 2048.
+	MaxBytesAllLines     int                 // Max number of bytes of all log lines, defaults to 1MB.
+	SquaredDurations     bool                // Whether to send the squares of the time metrics of requests as <metric>_sq.
+	MeasureAllocations   bool                // Whether to send heap allocations (process wide) during the request.
+	Sampler              Sampler             // Decides which requests are sent to logjam. Defaults to all requests.
+	SoftExceptions       []string            // Regular expressions matching exception tags which don't raise the request severity.
+	MaxFieldBytes        int                 // Field values exceeding this size are truncated, defaults to 64KB.
+	PoolRequests         bool                // Whether to reuse requests after Finish. Requests must not be used after Finish then.
+	SlowRequestThreshold time.Duration       // Requests taking longer get a WARN line and a slow_request exception tag.
+	TracePolicy          TracePolicy         // Whether the middleware continues incoming traces. Defaults to TraceContinue.
+	IDGenerator          func() string       // Generates request ids, defaults to version 4 UUIDs without dashes.
+	FilterParameters     []string            // Query and body parameters containing one of these (case insensitive) are filtered.
+	TraceHeader          string              // Header carrying trace ids of incoming and outgoing requests, defaults to X-Logjam-Trace-Id.
+	IPObfuscator         func(string) string // Obfuscates IP addresses if ObfuscateIPs is set, e.g. by hashing. Defaults to masking.
+	IPv4MaskBits         int                 // Number of leading bits of IPv4 addresses kept when masking, defaults to 24.
+	IPv6MaskBits         int                 // Number of leading bits of IPv6 addresses kept when masking, defaults to 112.
+	InternalNetworks     []string            // CIDRs of internal networks. If set, requests get a caller_network field (internal or external).
 }
 
 // ActionNameExtractor takes a HTTP request and returns a logjam conformant action name.
 type ActionNameExtractor func(*http.Request) string
 
+// Sampler decides whether a request with the given action name is sent to logjam. The
+// HTTP request is nil for requests not created by the logjam middleware. Requests which
+// have not been sampled are still sent if they end with an error, i.e. a response code
+// of 500 or higher, a log line with severity ERROR or higher, or a hard exception.
+type Sampler func(action string, r *http.Request) bool
+
 // NewAgent returns a new logjam agent.
 func NewAgent(options *Options) *Agent {
 	agent := &Agent{Options: *options}
@@ -83,6 +117,37 @@ func NewAgent(options *Options) *Agent {
 	if agent.MaxBytesAllLines == 0 {
 		agent.MaxBytesAllLines = maxBytesAllLinesDefault
 	}
+	if agent.MaxFieldBytes == 0 {
+		agent.MaxFieldBytes = maxFieldBytesDefault
+	}
+	if agent.IPv4MaskBits <= 0 || agent.IPv4MaskBits > 32 {
+		agent.IPv4MaskBits = ipv4MaskBitsDefault
+	}
+	if agent.IPv6MaskBits <= 0 || agent.IPv6MaskBits > 128 {
+		agent.IPv6MaskBits = ipv6MaskBitsDefault
+	}
+	if agent.TraceHeader == "" {
+		agent.TraceHeader = "X-Logjam-Trace-Id"
+	}
+	for _, pattern := range agent.SoftExceptions {
+		matcher, err := regexp.Compile(pattern)
+		if err != nil {
+			agent.Logger.Println("logjam: ignoring invalid soft exception pattern:", err)
+			continue
+		}
+		agent.soft = append(agent.soft, matcher)
+	}
+	for _, filter := range agent.FilterParameters {
+		agent.filterParameters = append(agent.filterParameters, strings.ToLower(filter))
+	}
+	for _, cidr := range agent.InternalNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			agent.Logger.Println("logjam: ignoring invalid internal network:", err)
+			continue
+		}
+		agent.internalNetworks = append(agent.internalNetworks, network)
+	}
 	agent.setSocketDefaults()
 	agent.stream = agent.AppName + "-" + agent.EnvName
 	agent.topic = "logs." + agent.AppName + "." + agent.EnvName
@@ -96,6 +161,55 @@ func NewAgent(options *Options) *Agent {
 	return agent
 }
 
+// isSoftException returns whether the given exception tag has been classified as a soft
+// exception. Soft exceptions are reported to logjam, but, in contrast to hard exceptions,
+// don't raise the severity of the request to ERROR.
+func (a *Agent) isSoftException(name string) bool {
+	for _, matcher := range a.soft {
+		if matcher.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// callerNetwork classifies the given client IP as internal or external according to the
+// InternalNetworks option. Returns an empty string if no internal networks are configured.
+func (a *Agent) callerNetwork(ip string) string {
+	if len(a.internalNetworks) == 0 {
+		return ""
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		for _, network := range a.internalNetworks {
+			if network.Contains(parsed) {
+				return "internal"
+			}
+		}
+	}
+	return "external"
+}
+
+// AttachCount increments a counter which is added to the next request sent to logjam. Use
+// it for process wide observations made in the background, like the state of connections
+// to other services, which can't be attributed to a particular request.
+func (a *Agent) AttachCount(key string, value int64) {
+	a.attachedMutex.Lock()
+	defer a.attachedMutex.Unlock()
+	if a.attached == nil {
+		a.attached = map[string]int64{}
+	}
+	a.attached[key] += value
+}
+
+// takeAttached returns the attached counters and resets them.
+func (a *Agent) takeAttached() map[string]int64 {
+	a.attachedMutex.Lock()
+	defer a.attachedMutex.Unlock()
+	attached := a.attached
+	a.attached = nil
+	return attached
+}
+
 // Shutdown the agent.
 func (a *Agent) Shutdown() {
 	a.mutex.Lock()
@@ -185,7 +299,7 @@ func augmentConnectionSpec(spec string, defaultPort int) string {
 	return fmt.Sprintf("%s://%s:%s", protocol, host, port)
 }
 
-func (a *Agent) sendMessage(msg []byte) {
+func (a *Agent) sendMessage(stream, topic string, msg []byte) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 	if a.socket == nil {
@@ -193,7 +307,7 @@ func (a *Agent) sendMessage(msg []byte) {
 	}
 	a.sequence++
 	meta := packInfo(time.Now(), a.sequence)
-	_, err := a.socket.SendMessage(a.stream, a.topic, msg, meta)
+	_, err := a.socket.SendMessage(stream, topic, msg, meta)
 	if err != nil {
 		a.Logger.Println(err)
 	}
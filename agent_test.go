@@ -35,3 +35,15 @@ func TestAgentOptionsInit(t *testing.T) {
 		})
 	})
 }
+
+func TestCallerNetwork(t *testing.T) {
+	Convey("caller network classification", t, func() {
+		agent := NewAgent(&Options{InternalNetworks: []string{"10.0.0.0/8", "fd00::/8", "invalid"}})
+		So(agent.internalNetworks, ShouldHaveLength, 2)
+		So(agent.callerNetwork("10.1.2.3"), ShouldEqual, "internal")
+		So(agent.callerNetwork("fd12::1"), ShouldEqual, "internal")
+		So(agent.callerNetwork("192.168.0.1"), ShouldEqual, "external")
+		So(agent.callerNetwork(""), ShouldEqual, "external")
+		So(NewAgent(&Options{}).callerNetwork("10.1.2.3"), ShouldEqual, "")
+	})
+}
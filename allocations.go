@@ -0,0 +1,33 @@
+package logjam
+
+import runtimemetrics "runtime/metrics"
+
+// allocations holds the cumulative heap allocation counters of the process at a given
+// point in time.
+type allocations struct {
+	bytes   uint64 // Cumulative number of bytes allocated on the heap.
+	objects uint64 // Cumulative number of heap objects allocated.
+}
+
+// readAllocations reads the heap allocation counters from runtime/metrics, which, unlike
+// runtime.ReadMemStats, does not stop the world.
+func readAllocations() allocations {
+	samples := []runtimemetrics.Sample{
+		{Name: "/gc/heap/allocs:bytes"},
+		{Name: "/gc/heap/allocs:objects"},
+	}
+	runtimemetrics.Read(samples)
+	a := allocations{}
+	if samples[0].Value.Kind() == runtimemetrics.KindUint64 {
+		a.bytes = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == runtimemetrics.KindUint64 {
+		a.objects = samples[1].Value.Uint64()
+	}
+	return a
+}
+
+// since returns the allocations which happened between start and a.
+func (a allocations) since(start allocations) allocations {
+	return allocations{bytes: a.bytes - start.bytes, objects: a.objects - start.objects}
+}
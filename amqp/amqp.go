@@ -0,0 +1,48 @@
+package amqp
+
+import (
+	"context"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/xing/logjam-agent-go"
+)
+
+// Publisher is implemented by *amqp.Channel.
+type Publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+var _ Publisher = (*amqp.Channel)(nil)
+
+// SetHeaders adds the logjam call headers of the logjam request found in the context to the
+// message headers, like logjam.SetCallHeaders does for HTTP requests, so that consumers can
+// link their logjam requests to the publishing request.
+func SetHeaders(ctx context.Context, msg *amqp.Publishing) {
+	headers := logjam.CallHeaders(ctx)
+	if headers == nil {
+		return
+	}
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	for name := range headers {
+		msg.Headers[name] = headers.Get(name)
+	}
+}
+
+// Publish sets the logjam call headers on the message and publishes it, recording the time
+// spent as amqp_publish_time and the number of messages published as amqp_publish_calls on
+// the logjam request found in the context.
+func Publish(ctx context.Context, publisher Publisher, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return publisher.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	}
+	SetHeaders(ctx, &msg)
+	start := time.Now()
+	err := publisher.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	request.AddDuration("amqp_publish_time", time.Since(start))
+	request.Count("amqp_publish_calls")
+	return err
+}
@@ -0,0 +1,46 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+type recordingPublisher struct {
+	published []amqp.Publishing
+}
+
+func (p *recordingPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.published = append(p.published, msg)
+	return nil
+}
+
+func TestPublish(t *testing.T) {
+	collector := logjamtest.NewCollector("amqp-test", logjam.Options{
+		AppName: "appName",
+		EnvName: "envName",
+	})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("publishing messages with call headers", t, func() {
+		request := agent.NewRequest("Users#create")
+		ctx := request.NewContext(context.Background())
+		publisher := &recordingPublisher{}
+
+		So(Publish(ctx, publisher, "users", "created", false, false, amqp.Publishing{Body: []byte("42")}), ShouldBeNil)
+		headers := publisher.published[0].Headers
+		So(headers["X-Logjam-Action"], ShouldEqual, "Users#create")
+		So(headers["X-Logjam-Caller-Id"], ShouldStartWith, "appName-envName-")
+		So(headers["X-Logjam-Trace-Id"], ShouldEqual, request.TraceID())
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output, ShouldContainKey, "amqp_publish_time")
+		So(output["amqp_publish_calls"], ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,20 @@
+module github.com/xing/logjam-agent-go/amqp
+
+go 1.22
+
+require (
+	github.com/rabbitmq/amqp091-go v1.9.0
+	github.com/smartystreets/goconvey v1.6.4
+	github.com/xing/logjam-agent-go v0.1.0
+)
+
+require (
+	github.com/felixge/httpsnoop v1.0.3 // indirect
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/gopherjs/gopherjs v0.0.0-20181017120253-0766667cb4d1 // indirect
+	github.com/jtolds/gls v4.20.0+incompatible // indirect
+	github.com/pebbe/zmq4 v1.2.0 // indirect
+	github.com/smartystreets/assertions v0.0.0-20180927180507-b2de0cb4f26d // indirect
+)
+
+replace github.com/xing/logjam-agent-go => ../
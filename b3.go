@@ -0,0 +1,76 @@
+package logjam
+
+import (
+	"net/http"
+	"strings"
+)
+
+// B3Format selects the Zipkin B3 header formats the middleware extracts trace ids from and
+// SetCallHeaders injects into outgoing requests. Formats can be combined using bitwise or.
+type B3Format int
+
+const (
+	// B3Single denotes the single b3 header.
+	B3Single B3Format = 1 << iota
+	// B3Multi denotes the X-B3-TraceId, X-B3-SpanId and X-B3-Sampled headers.
+	B3Multi
+)
+
+// b3TraceID extracts trace id and sampling state from the B3 headers of an incoming HTTP
+// request. The sampling state is one of "0", "1", "d" (debug) or empty if the caller
+// deferred the sampling decision. Returns empty strings if no valid B3 trace id was found.
+func (f B3Format) b3TraceID(r *http.Request) (traceID string, sampled string) {
+	if f&B3Single != 0 {
+		if b3 := r.Header.Get("b3"); b3 != "" {
+			parts := strings.Split(b3, "-")
+			if len(parts) >= 2 && isValidB3TraceID(parts[0]) {
+				if len(parts) >= 3 {
+					sampled = parts[2]
+				}
+				return parts[0], sampled
+			}
+		}
+	}
+	if f&B3Multi != 0 {
+		if traceID := r.Header.Get("X-B3-TraceId"); isValidB3TraceID(traceID) {
+			sampled = r.Header.Get("X-B3-Sampled")
+			if r.Header.Get("X-B3-Flags") == "1" {
+				sampled = "d"
+			}
+			return traceID, sampled
+		}
+	}
+	return "", ""
+}
+
+// setB3Headers adds the B3 headers selected by the middleware which created the request
+// to the given header. Does nothing if the trace id of the request isn't a valid B3 trace
+// id.
+func (r *Request) setB3Headers(header http.Header) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.b3 == 0 || !isValidB3TraceID(r.traceID) {
+		return
+	}
+	spanID := r.spanID()
+	sampled := r.b3Sampled
+	if sampled == "" {
+		sampled = "1"
+	}
+	if r.b3&B3Single != 0 {
+		header.Set("b3", r.traceID+"-"+spanID+"-"+sampled)
+	}
+	if r.b3&B3Multi != 0 {
+		header.Set("X-B3-TraceId", r.traceID)
+		header.Set("X-B3-SpanId", spanID)
+		if sampled == "d" {
+			header.Set("X-B3-Flags", "1")
+		} else {
+			header.Set("X-B3-Sampled", sampled)
+		}
+	}
+}
+
+func isValidB3TraceID(traceID string) bool {
+	return (len(traceID) == 16 || len(traceID) == 32) && isLowerHex(traceID) && strings.Trim(traceID, "0") != ""
+}
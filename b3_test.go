@@ -0,0 +1,71 @@
+package logjam
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestB3(t *testing.T) {
+	Convey("B3 propagation", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		traceID := "463ac35c9f6413ad48485a3953bb6124"
+
+		serve := func(format B3Format, incoming *http.Request) *http.Request {
+			outgoing := httptest.NewRequest("GET", "/", nil)
+			handler := agent.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				SetCallHeaders(r.Context(), outgoing)
+			}), MiddlewareOptions{B3: format})
+			handler.ServeHTTP(httptest.NewRecorder(), incoming)
+			return outgoing
+		}
+
+		Convey("single header", func() {
+			incoming := httptest.NewRequest("GET", "/", nil)
+			incoming.Header.Set("b3", traceID+"-0020000000000001-0")
+			outgoing := serve(B3Single, incoming)
+			So(outgoing.Header.Get("X-Logjam-Trace-Id"), ShouldEqual, traceID)
+			So(outgoing.Header.Get("b3"), ShouldStartWith, traceID+"-")
+			So(outgoing.Header.Get("b3"), ShouldEndWith, "-0")
+			So(outgoing.Header.Get("X-B3-TraceId"), ShouldEqual, "")
+		})
+
+		Convey("multi headers", func() {
+			incoming := httptest.NewRequest("GET", "/", nil)
+			incoming.Header.Set("X-B3-TraceId", "a3ce929d0e0e4736")
+			incoming.Header.Set("X-B3-SpanId", "00f067aa0ba902b7")
+			incoming.Header.Set("X-B3-Flags", "1")
+			outgoing := serve(B3Single|B3Multi, incoming)
+			So(outgoing.Header.Get("X-Logjam-Trace-Id"), ShouldEqual, "a3ce929d0e0e4736")
+			So(outgoing.Header.Get("X-B3-TraceId"), ShouldEqual, "a3ce929d0e0e4736")
+			So(outgoing.Header.Get("X-B3-SpanId"), ShouldHaveLength, 16)
+			So(outgoing.Header.Get("X-B3-Flags"), ShouldEqual, "1")
+			So(outgoing.Header.Get("b3"), ShouldEndWith, "-d")
+		})
+
+		Convey("new traces", func() {
+			outgoing := serve(B3Multi, httptest.NewRequest("GET", "/", nil))
+			So(outgoing.Header.Get("X-B3-TraceId"), ShouldEqual, outgoing.Header.Get("X-Logjam-Trace-Id"))
+			So(outgoing.Header.Get("X-B3-Sampled"), ShouldEqual, "1")
+		})
+
+		Convey("disabled", func() {
+			incoming := httptest.NewRequest("GET", "/", nil)
+			incoming.Header.Set("b3", traceID+"-0020000000000001-1")
+			outgoing := serve(0, incoming)
+			So(outgoing.Header.Get("X-Logjam-Trace-Id"), ShouldNotEqual, traceID)
+			So(outgoing.Header.Get("b3"), ShouldEqual, "")
+		})
+
+		Convey("invalid trace ids", func() {
+			So(isValidB3TraceID("0000000000000000"), ShouldBeFalse)
+			So(isValidB3TraceID("463AC35C9F6413AD"), ShouldBeFalse)
+			So(isValidB3TraceID("463ac35c9f6413ad4"), ShouldBeFalse)
+			So(isValidB3TraceID("463ac35c9f6413ad"), ShouldBeTrue)
+		})
+	})
+}
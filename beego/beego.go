@@ -0,0 +1,80 @@
+package beego
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/beego/beego/v2/server/web"
+	beecontext "github.com/beego/beego/v2/server/web/context"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/names"
+)
+
+type contextKey int
+
+const beegoCallKey contextKey = 0
+
+// beegoCall carries the beego context and the next filter of a request through the logjam
+// handler, which is shared by all requests.
+type beegoCall struct {
+	context *beecontext.Context
+	next    web.FilterFunc
+}
+
+// Filters provides a beego filter chain wrapping beego requests in the logjam middleware,
+// and a filter naming the requests after the controllers handling them. Create them using
+// NewFilters and install them using InsertFilters.
+type Filters struct {
+	handler http.Handler
+}
+
+// NewFilters creates filters sending requests to the given agent. The logjam middleware
+// is configured with the given options.
+func NewFilters(agent *logjam.Agent, options logjam.MiddlewareOptions) *Filters {
+	h := agent.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := r.Context().Value(beegoCallKey).(*beegoCall)
+		ctx := call.context
+		ctx.Request = r
+		ctx.ResponseWriter.ResponseWriter = w
+		call.next(ctx)
+	}), options)
+	return &Filters{handler: h}
+}
+
+// InsertFilters installs the logjam filters for all beego routes.
+func InsertFilters(agent *logjam.Agent, options logjam.MiddlewareOptions) {
+	f := NewFilters(agent, options)
+	web.InsertFilterChain("*", f.Chain)
+	web.InsertFilter("*", web.BeforeExec, f.Exec)
+}
+
+// Chain passes beego requests through the logjam middleware, which creates the logjam
+// request, handles panics and finishes the request once beego is done with it. Install it
+// using web.InsertFilterChain. The request gets an action name extracted by the agent's
+// ActionNameExtractor until routing has determined the controller.
+func (f *Filters) Chain(next web.FilterFunc) web.FilterFunc {
+	return func(ctx *beecontext.Context) {
+		r := ctx.Request
+		call := &beegoCall{context: ctx, next: next}
+		f.handler.ServeHTTP(ctx.ResponseWriter.ResponseWriter, r.WithContext(context.WithValue(r.Context(), beegoCallKey, call)))
+	}
+}
+
+// Exec names the logjam request after the controller and method handling it, e.g.
+// "Users#show_profile" for UsersController.ShowProfile. Install it at web.BeforeExec.
+func (f *Filters) Exec(ctx *beecontext.Context) {
+	request := logjam.GetRequest(ctx.Request.Context())
+	if request == nil || ctx.Input.RunController == nil {
+		return
+	}
+	request.ChangeAction(ctx.ResponseWriter, actionName(ctx.Input.RunController.Name(), ctx.Input.RunMethod))
+}
+
+func actionName(controller, method string) string {
+	controller = strings.TrimSuffix(controller, "Controller")
+	if controller == "" {
+		controller = "Unknown"
+	}
+	return controller + "#" + names.SnakeCase(method)
+}
@@ -0,0 +1,73 @@
+package beego
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/beego/beego/v2/server/web"
+	beecontext "github.com/beego/beego/v2/server/web/context"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+type UserProfilesController struct{}
+
+func TestNames(t *testing.T) {
+	Convey("naming requests after controllers and methods", t, func() {
+		So(actionName("UserProfilesController", "Get"), ShouldEqual, "UserProfiles#get")
+		So(actionName("UsersController", "ShowProfile"), ShouldEqual, "Users#show_profile")
+		So(actionName("Controller", "Get"), ShouldEqual, "Unknown#get")
+	})
+}
+
+func TestFilters(t *testing.T) {
+	collector := logjamtest.NewCollector("beego-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+	filters := NewFilters(agent, logjam.MiddlewareOptions{})
+
+	perform := func(handler web.FilterFunc) *httptest.ResponseRecorder {
+		ctx := beecontext.NewContext()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/user_profiles/123", nil)
+		r.Header.Set("X-Logjam-Caller-Id", "caller-id")
+		ctx.Reset(w, r)
+		filters.Chain(handler)(ctx)
+		return w
+	}
+
+	Convey("creating and finishing requests", t, func() {
+		w := perform(func(ctx *beecontext.Context) {
+			So(logjam.GetRequest(ctx.Request.Context()), ShouldNotBeNil)
+			ctx.Input.RunController = reflect.TypeOf(UserProfilesController{})
+			ctx.Input.RunMethod = "ShowProfile"
+			filters.Exec(ctx)
+			ctx.ResponseWriter.WriteHeader(http.StatusNotFound)
+		})
+		So(w.Code, ShouldEqual, http.StatusNotFound)
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "UserProfiles#show_profile")
+		So(w.Header().Get("X-Logjam-Request-Id"), ShouldNotBeEmpty)
+
+		output := collector.Receive()
+		So(output["action"], ShouldEqual, "UserProfiles#show_profile")
+		So(output["code"], ShouldEqual, 404)
+		So(output["caller_id"], ShouldEqual, "caller-id")
+		So(output, ShouldContainKey, "request_info")
+		So(output, ShouldContainKey, "ip")
+	})
+
+	Convey("finishing requests of panicking handlers", t, func() {
+		w := perform(func(ctx *beecontext.Context) {
+			panic("boom")
+		})
+		So(w.Code, ShouldEqual, http.StatusInternalServerError)
+
+		output := collector.Receive()
+		So(output["code"], ShouldEqual, 500)
+		So(output["severity"], ShouldEqual, float64(logjam.FATAL))
+		So(agent.InFlight(), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,77 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/xing/logjam-agent-go"
+)
+
+// Reader wraps a stream read from a blob store, like the body of an S3 GetObject response,
+// recording the bytes read as blob_bytes and the time spent reading as blob_time on the
+// logjam request found in the context given to NewReader.
+type Reader struct {
+	r       io.Reader
+	request *logjam.Request
+}
+
+// NewReader wraps the given reader. Reads aren't recorded if the context has no logjam
+// request.
+func NewReader(ctx context.Context, r io.Reader) *Reader {
+	return &Reader{r: r, request: logjam.GetRequest(ctx)}
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.request == nil {
+		return r.r.Read(p)
+	}
+	start := time.Now()
+	n, err := r.r.Read(p)
+	r.request.AddDuration("blob_time", time.Since(start))
+	r.request.AddBytes(logjam.BlobBytes, int64(n))
+	return n, err
+}
+
+// Close closes the wrapped reader if it is an io.Closer.
+func (r *Reader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Writer wraps a stream written to a blob store, like the pipe feeding an S3 upload,
+// recording the bytes written as blob_bytes and the time spent writing as blob_time on the
+// logjam request found in the context given to NewWriter.
+type Writer struct {
+	w       io.Writer
+	request *logjam.Request
+}
+
+// NewWriter wraps the given writer. Writes aren't recorded if the context has no logjam
+// request.
+func NewWriter(ctx context.Context, w io.Writer) *Writer {
+	return &Writer{w: w, request: logjam.GetRequest(ctx)}
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.request == nil {
+		return w.w.Write(p)
+	}
+	start := time.Now()
+	n, err := w.w.Write(p)
+	w.request.AddDuration("blob_time", time.Since(start))
+	w.request.AddBytes(logjam.BlobBytes, int64(n))
+	return n, err
+}
+
+// Close closes the wrapped writer if it is an io.Closer.
+func (w *Writer) Close() error {
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
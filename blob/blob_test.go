@@ -0,0 +1,47 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestTransfers(t *testing.T) {
+	collector := logjamtest.NewCollector("blob-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("recording uploads and downloads", t, func() {
+		request := agent.NewRequest("Images#copy")
+		ctx := request.NewContext(context.Background())
+
+		var uploaded bytes.Buffer
+		download := NewReader(ctx, ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 1000))))
+		upload := NewWriter(ctx, &uploaded)
+		n, err := io.Copy(upload, download)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1000)
+		So(download.Close(), ShouldBeNil)
+		So(upload.Close(), ShouldBeNil)
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output, ShouldContainKey, "blob_time")
+		So(output["blob_bytes"], ShouldEqual, 2000)
+	})
+
+	Convey("passing streams through without logjam request", t, func() {
+		var out bytes.Buffer
+		n, err := io.Copy(NewWriter(context.Background(), &out), NewReader(context.Background(), strings.NewReader("data")))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 4)
+		So(out.String(), ShouldEqual, "data")
+	})
+}
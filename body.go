@@ -0,0 +1,117 @@
+package logjam
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// bufferedBody replaces a request body which has been partially or completely read by the
+// middleware, so that handlers can still read the complete body.
+type bufferedBody struct {
+	io.Reader
+	io.Closer
+	size int64 // Size of the body if it has been read completely, -1 otherwise.
+}
+
+// bufferBody reads up to limit bytes of the request body and replaces the body with a
+// reader returning the same data. It returns the buffered bytes and whether the complete
+// body fit into the limit.
+func bufferBody(r *http.Request, limit int64) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength > limit {
+		return nil, false
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	complete := err == nil && int64(len(buf)) <= limit
+	if complete {
+		r.Body = bufferedBody{Reader: bytes.NewReader(buf), Closer: r.Body, size: int64(len(buf))}
+	} else {
+		r.Body = bufferedBody{Reader: io.MultiReader(bytes.NewReader(buf), r.Body), Closer: r.Body, size: -1}
+	}
+	return buf, complete
+}
+
+// hasContentType returns whether the request has the given media type.
+func hasContentType(r *http.Request, mediaType string) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && t == mediaType
+}
+
+// bufferForm parses url encoded form bodies not exceeding the FormBodyLimit option into
+// r.PostForm, so that they can be sent to logjam as body parameters. The body remains
+// readable for handlers.
+func (m *middleware) bufferForm(r *http.Request) {
+	if m.FormBodyLimit <= 0 || r.PostForm != nil || !hasContentType(r, "application/x-www-form-urlencoded") {
+		return
+	}
+	if r.Method != "POST" && r.Method != "PUT" && r.Method != "PATCH" {
+		return
+	}
+	buf, complete := bufferBody(r, m.FormBodyLimit)
+	if !complete {
+		return
+	}
+	if values, err := url.ParseQuery(string(buf)); err == nil {
+		r.PostForm = values
+	}
+}
+
+// bufferJSON reads JSON bodies not exceeding the JSONBodyLimit option and returns them as
+// body parameters, with filtered parameters replaced and long strings truncated. Like in
+// Rails, bodies which aren't JSON objects are wrapped in a "_json" parameter. The body
+// remains readable for handlers.
+func (m *middleware) bufferJSON(r *http.Request) map[string]interface{} {
+	if m.JSONBodyLimit <= 0 || !hasContentType(r, "application/json") {
+		return nil
+	}
+	buf, complete := bufferBody(r, m.JSONBodyLimit)
+	if !complete || len(buf) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return nil
+	}
+	if parameters, ok := m.agent.filterJSON(body).(map[string]interface{}); ok {
+		return parameters
+	}
+	return map[string]interface{}{"_json": m.agent.filterJSON(body)}
+}
+
+// filterJSON replaces the values of filtered parameters in decoded JSON values and
+// truncates strings exceeding the MaxFieldBytes option.
+func (a *Agent) filterJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			if a.filterParameter(key) {
+				v[key] = filteredParameter
+			} else {
+				v[key] = a.filterJSON(val)
+			}
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = a.filterJSON(val)
+		}
+	case string:
+		return truncateField(v, a.MaxFieldBytes)
+	}
+	return value
+}
+
+// requestSize returns the size of the request body: the actual size if the middleware
+// buffered the complete body, the declared Content-Length otherwise. Returns -1 if the
+// size is unknown.
+func requestSize(r *http.Request) int64 {
+	if body, ok := r.Body.(bufferedBody); ok && body.size >= 0 {
+		return body.size
+	}
+	return r.ContentLength
+}
@@ -0,0 +1,134 @@
+package logjam
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBufferBody(t *testing.T) {
+	Convey("Buffering request bodies", t, func() {
+		Convey("keeps small bodies readable", func() {
+			r := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+			buf, complete := bufferBody(r, 10)
+			So(complete, ShouldBeTrue)
+			So(string(buf), ShouldEqual, "hello")
+			body, _ := ioutil.ReadAll(r.Body)
+			So(string(body), ShouldEqual, "hello")
+		})
+
+		Convey("keeps large bodies readable", func() {
+			r := httptest.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader("hello world")))
+			_, complete := bufferBody(r, 5)
+			So(complete, ShouldBeFalse)
+			body, _ := ioutil.ReadAll(r.Body)
+			So(string(body), ShouldEqual, "hello world")
+		})
+	})
+}
+
+func TestFormBodyParameters(t *testing.T) {
+	Convey("Capturing url encoded form bodies", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), FilterParameters: []string{"password"}})
+		m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{FormBodyLimit: 1024}).(*middleware)
+
+		newRequest := func(body string) *http.Request {
+			r := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return r
+		}
+
+		Convey("captures and filters form values", func() {
+			r := newRequest("user=joe&password=secret")
+			m.bufferForm(r)
+			So(m.requestInfo(r, nil)["body_parameters"], ShouldResemble, map[string]interface{}{
+				"user":     "joe",
+				"password": "[FILTERED]",
+			})
+			So(r.FormValue("user"), ShouldEqual, "joe")
+		})
+
+		Convey("uses forms already parsed by the handler", func() {
+			m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{}).(*middleware)
+			r := newRequest("user=joe")
+			m.bufferForm(r)
+			So(m.requestInfo(r, nil), ShouldNotContainKey, "body_parameters")
+			r.ParseForm()
+			So(m.requestInfo(r, nil)["body_parameters"], ShouldResemble, map[string]interface{}{"user": "joe"})
+		})
+
+		Convey("ignores bodies exceeding the limit", func() {
+			r := newRequest("user=" + strings.Repeat("x", 2048))
+			m.bufferForm(r)
+			So(m.requestInfo(r, nil), ShouldNotContainKey, "body_parameters")
+			So(r.FormValue("user"), ShouldHaveLength, 2048)
+		})
+	})
+}
+
+func TestJSONBodyParameters(t *testing.T) {
+	Convey("Capturing JSON bodies", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), FilterParameters: []string{"password"}, MaxFieldBytes: 30})
+		m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{JSONBodyLimit: 1024}).(*middleware)
+
+		newRequest := func(body string) *http.Request {
+			r := httptest.NewRequest("POST", "/users", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json; charset=utf-8")
+			return r
+		}
+
+		Convey("captures, filters and truncates JSON objects", func() {
+			body := `{"user":{"name":"joe","password":"secret"},"ids":[1,2],"bio":"` + strings.Repeat("x", 50) + `"}`
+			r := newRequest(body)
+			parameters := m.bufferJSON(r)
+			So(parameters["user"], ShouldResemble, map[string]interface{}{"name": "joe", "password": "[FILTERED]"})
+			So(parameters["ids"], ShouldResemble, []interface{}{json.Number("1"), json.Number("2")})
+			So(parameters["bio"], ShouldHaveLength, 30)
+			So(m.requestInfo(r, parameters)["body_parameters"], ShouldResemble, parameters)
+			read, _ := ioutil.ReadAll(r.Body)
+			So(string(read), ShouldEqual, body)
+		})
+
+		Convey("wraps other JSON values", func() {
+			So(m.bufferJSON(newRequest(`["a","b"]`)), ShouldResemble, map[string]interface{}{"_json": []interface{}{"a", "b"}})
+		})
+
+		Convey("ignores invalid and large bodies", func() {
+			So(m.bufferJSON(newRequest(`{"a":`)), ShouldBeNil)
+			So(m.bufferJSON(newRequest(`{"a":"`+strings.Repeat("x", 2048)+`"}`)), ShouldBeNil)
+		})
+
+		Convey("is disabled by default", func() {
+			m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{}).(*middleware)
+			So(m.bufferJSON(newRequest(`{"a":1}`)), ShouldBeNil)
+		})
+	})
+}
+
+func TestRequestSize(t *testing.T) {
+	Convey("Request size", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{JSONBodyLimit: 1024}).(*middleware)
+
+		Convey("uses the declared Content-Length", func() {
+			r := httptest.NewRequest("POST", "/", strings.NewReader("some body"))
+			So(requestSize(r), ShouldEqual, 9)
+			So(requestSize(httptest.NewRequest("GET", "/", nil)), ShouldEqual, 0)
+		})
+
+		Convey("uses the buffered size", func() {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(`{"a":1}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.ContentLength = -1
+			m.bufferJSON(r)
+			So(requestSize(r), ShouldEqual, 7)
+		})
+	})
+}
@@ -0,0 +1,46 @@
+package logjam
+
+import (
+	"context"
+	"sync"
+)
+
+// CountCache counts a lookup in the in-process cache with the given name as cache hit or
+// miss on the logjam request found in the context, using the counters <name>_cache_hits
+// and <name>_cache_misses, e.g. users_cache_hits for the cache named "users".
+func CountCache(ctx context.Context, name string, hit bool) {
+	if request := GetRequest(ctx); request != nil {
+		request.CountCache(name, hit)
+	}
+}
+
+// CountCache counts a lookup in the in-process cache with the given name as cache hit or
+// miss, like the function CountCache.
+func (r *Request) CountCache(name string, hit bool) {
+	if hit {
+		r.Count(name + "_cache_hits")
+	} else {
+		r.Count(name + "_cache_misses")
+	}
+}
+
+// Getter is implemented by common in-process caches, like the LRU caches of version 1 of
+// github.com/hashicorp/golang-lru. Use CountCache directly for other caches.
+type Getter interface {
+	Get(key interface{}) (value interface{}, ok bool)
+}
+
+// CacheGet looks up the key in the cache and counts the lookup using CountCache.
+func CacheGet(ctx context.Context, name string, cache Getter, key interface{}) (interface{}, bool) {
+	value, ok := cache.Get(key)
+	CountCache(ctx, name, ok)
+	return value, ok
+}
+
+// CacheLoad looks up the key in a sync.Map used as cache and counts the lookup using
+// CountCache.
+func CacheLoad(ctx context.Context, name string, cache *sync.Map, key interface{}) (interface{}, bool) {
+	value, ok := cache.Load(key)
+	CountCache(ctx, name, ok)
+	return value, ok
+}
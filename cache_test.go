@@ -0,0 +1,46 @@
+package logjam
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type mapCache map[interface{}]interface{}
+
+func (c mapCache) Get(key interface{}) (interface{}, bool) {
+	value, ok := c[key]
+	return value, ok
+}
+
+func TestCountCache(t *testing.T) {
+	Convey("counting cache hits and misses", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		request := agent.NewRequest("Users#show")
+		ctx := request.NewContext(context.Background())
+
+		CountCache(ctx, "sessions", true)
+		cache := mapCache{"alice": 1}
+		value, ok := CacheGet(ctx, "users", cache, "alice")
+		So(value, ShouldEqual, 1)
+		So(ok, ShouldBeTrue)
+		_, ok = CacheGet(ctx, "users", cache, "bob")
+		So(ok, ShouldBeFalse)
+		var profiles sync.Map
+		_, ok = CacheLoad(ctx, "profiles", &profiles, "alice")
+		So(ok, ShouldBeFalse)
+		CountCache(context.Background(), "users", true)
+
+		So(request.counts, ShouldResemble, map[string]int64{
+			"sessions_cache_hits":   1,
+			"users_cache_hits":      1,
+			"users_cache_misses":    1,
+			"profiles_cache_misses": 1,
+		})
+	})
+}
@@ -1,9 +1,12 @@
 package logjam
 
 import (
+	"bufio"
 	"io"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/felixge/httpsnoop"
 )
@@ -22,6 +25,73 @@ type metrics struct {
 	Written int64
 	// Whether the header has been written already
 	HeaderWritten bool
+	// ContentType is the value of the Content-Type response header when the
+	// response header was written, or the content type net/http sniffs from the
+	// first write if the handler didn't set one.
+	ContentType string
+	// FirstByte is the time the handler first called WriteHeader, Write or
+	// ReadFrom, or zero if it never did.
+	FirstByte time.Time
+	// Flushed is the time the handler first flushed the response, or zero if it
+	// never did.
+	Flushed time.Time
+	// Hijacked is the connection taken over by the handler, or nil if the handler
+	// didn't hijack the connection.
+	Hijacked *hijackedConn
+}
+
+// firstByte records the time of the first write to the response.
+func (m *metrics) firstByte() {
+	if m.FirstByte.IsZero() {
+		m.FirstByte = time.Now()
+	}
+}
+
+// contentType records the content type of the response. If the handler didn't set one,
+// it is sniffed from the given data like net/http does, unless the handler suppressed
+// that by setting the Content-Type header to nil.
+func (m *metrics) contentType(header http.Header, p []byte) {
+	if m.ContentType != "" {
+		return
+	}
+	if values, set := header["Content-Type"]; set {
+		if len(values) > 0 {
+			m.ContentType = values[0]
+		}
+		return
+	}
+	if len(p) > 0 {
+		m.ContentType = http.DetectContentType(p)
+	}
+}
+
+// hijackedConn wraps a hijacked connection to find out when it gets closed.
+type hijackedConn struct {
+	net.Conn
+	start  time.Time     // When the connection was hijacked.
+	end    time.Time     // When the connection was closed.
+	once   sync.Once     // Guards closing the closed channel.
+	closed chan struct{} // Closed when the connection was closed.
+}
+
+func newHijackedConn(conn net.Conn) *hijackedConn {
+	return &hijackedConn{Conn: conn, start: time.Now(), closed: make(chan struct{})}
+}
+
+// Close closes the underlying connection and signals waiters on the closed channel.
+func (c *hijackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.end = time.Now()
+		close(c.closed)
+	})
+	return err
+}
+
+// duration returns for how long the connection was open after being hijacked. Must only
+// be called after the connection was closed.
+func (c *hijackedConn) duration() time.Duration {
+	return c.end.Sub(c.start)
 }
 
 // captureMetrics wraps the given hnd, executes it with the given w and r, and
@@ -35,7 +105,8 @@ func captureMetrics(hnd http.Handler, w http.ResponseWriter, r *http.Request, m
 // captureMetricsFn wraps w and calls fn with the wrapped w and returns the
 // resulting metrics. This is very similar to CaptureMetrics (which is just
 // sugar on top of this func), but is a more usable interface if your
-// application doesn't use the Go http.Handler interface.
+// application doesn't use the Go http.Handler interface. The wrapped w implements
+// Unwrap, so that http.ResponseController works for handlers down the chain.
 func captureMetricsFn(w http.ResponseWriter, fn func(http.ResponseWriter), m *metrics) {
 	var (
 		lock  sync.Mutex
@@ -45,6 +116,8 @@ func captureMetricsFn(w http.ResponseWriter, fn func(http.ResponseWriter), m *me
 					next(code)
 					lock.Lock()
 					defer lock.Unlock()
+					m.firstByte()
+					m.contentType(w.Header(), nil)
 					if !m.HeaderWritten {
 						m.Code = code
 						m.HeaderWritten = true
@@ -57,6 +130,10 @@ func captureMetricsFn(w http.ResponseWriter, fn func(http.ResponseWriter), m *me
 					n, err := next(p)
 					lock.Lock()
 					defer lock.Unlock()
+					m.firstByte()
+					if m.Written == 0 {
+						m.contentType(w.Header(), p)
+					}
 					m.Written += int64(n)
 					m.HeaderWritten = true
 					return n, err
@@ -68,11 +145,44 @@ func captureMetricsFn(w http.ResponseWriter, fn func(http.ResponseWriter), m *me
 					n, err := next(src)
 					lock.Lock()
 					defer lock.Unlock()
+					m.firstByte()
+					m.contentType(w.Header(), nil)
 					m.Written += n
 					m.HeaderWritten = true
 					return n, err
 				}
 			},
+
+			Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return func() {
+					next()
+					lock.Lock()
+					defer lock.Unlock()
+					if m.Flushed.IsZero() {
+						m.Flushed = time.Now()
+					}
+					m.HeaderWritten = true
+				}
+			},
+
+			Hijack: func(next httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+				return func() (net.Conn, *bufio.ReadWriter, error) {
+					conn, rw, err := next()
+					if err != nil {
+						return conn, rw, err
+					}
+					lock.Lock()
+					defer lock.Unlock()
+					if !m.HeaderWritten {
+						// Handlers hijack connections mostly to switch protocols, e.g. to
+						// WebSocket, writing the response header themselves.
+						m.Code = http.StatusSwitchingProtocols
+						m.HeaderWritten = true
+					}
+					m.Hijacked = newHijackedConn(conn)
+					return m.Hijacked, rw, err
+				}
+			},
 		}
 	)
 
@@ -0,0 +1,92 @@
+package chi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/xing/logjam-agent-go"
+)
+
+// methods are the HTTP methods checked when determining the methods allowed for a path.
+var methods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// ActionName returns a chi middleware setting the logjam action name of the requests
+// served by a route. Requires a logjam compatible action name of the form
+// (Module::)*Controller#action. Action names without "#" get the HTTP request method in
+// lowercase appended. Use it with chi's inline middleware:
+//
+//	r.With(chi.ActionName("Users#show")).Get("/users/{id}", ShowUser)
+func ActionName(actionName string) func(http.Handler) http.Handler {
+	appendMethod := !strings.Contains(actionName, "#")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if request := logjam.GetRequest(r.Context()); request != nil {
+				action := actionName
+				if appendMethod {
+					action += "#" + strings.ToLower(r.Method)
+				}
+				request.ChangeAction(w, action)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RouteTemplate is a chi middleware recording the pattern of the matched route on the
+// logjam request, so that the logjam middleware can derive action names for routes
+// without a logjam action name. Install it using r.Use(chi.RouteTemplate).
+func RouteTemplate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		// The pattern is complete only after routing has finished.
+		if request := logjam.GetRequest(r.Context()); request != nil {
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				if pattern := rctx.RoutePattern(); pattern != "" {
+					request.SetRouteTemplate(pattern)
+				}
+			}
+		}
+	})
+}
+
+// AddMethodNotAllowedHandlers installs a method not allowed handler on the given router,
+// which names requests with methods not registered for the path after the matching route,
+// e.g. "Users::Id#methodNotAllowed" for "/users/{id}". It responds with status code 405
+// and an Allow header listing the registered methods. It must be called after all
+// routes have been set up on the router.
+func AddMethodNotAllowedHandlers(r chi.Router) {
+	r.MethodNotAllowed(func(w http.ResponseWriter, req *http.Request) {
+		path := req.URL.RawPath
+		if path == "" {
+			path = req.URL.Path
+		}
+		allowed := []string{}
+		pattern := ""
+		rctx := chi.NewRouteContext()
+		for _, method := range methods {
+			rctx.Reset()
+			if r.Match(rctx, method, path) {
+				allowed = append(allowed, method)
+				pattern = rctx.RoutePattern()
+			}
+		}
+		action := "System#methodNotAllowed"
+		if pattern != "" {
+			action = methodNotAllowedActionName(pattern)
+		}
+		logjam.ChangeAction(req.Context(), w, action)
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+}
+
+func methodNotAllowedActionName(pattern string) string {
+	action := logjam.RouteTemplateActionName(http.MethodGet, pattern)
+	return strings.TrimSuffix(action, "#get") + "#methodNotAllowed"
+}
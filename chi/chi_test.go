@@ -0,0 +1,59 @@
+package chi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestChi(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(RouteTemplate)
+	somebody := func(w http.ResponseWriter, r *http.Request) {}
+	router.With(ActionName("Users#show")).Get("/users/{id}", somebody)
+	router.With(ActionName("Users")).Post("/users/{id}", somebody)
+	router.Get("/groups/{id}/members", somebody)
+	AddMethodNotAllowedHandlers(router)
+
+	collector := logjamtest.NewCollector("chi-test", logjam.Options{
+		ActionNameExtractor: func(r *http.Request) string { return "Unknown#get" },
+	})
+	defer collector.Close()
+	agent := collector.Agent
+	handler := agent.NewHandler(router, logjam.MiddlewareOptions{})
+
+	perform := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	receiveAction := func() interface{} {
+		output := collector.Receive()
+		return output["action"]
+	}
+
+	Convey("registering explicit action names", t, func() {
+		So(perform("GET", "/users/123").Header().Get("X-Logjam-Action"), ShouldEqual, "Users#show")
+		So(receiveAction(), ShouldEqual, "Users#show")
+		So(perform("POST", "/users/123").Header().Get("X-Logjam-Action"), ShouldEqual, "Users#post")
+		So(receiveAction(), ShouldEqual, "Users#post")
+	})
+
+	Convey("deriving action names from route patterns", t, func() {
+		So(perform("GET", "/groups/123/members").Code, ShouldEqual, http.StatusOK)
+		So(receiveAction(), ShouldEqual, "Groups::Id::Members#get")
+	})
+
+	Convey("naming requests with methods not allowed", t, func() {
+		w := perform("DELETE", "/users/123")
+		So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		So(w.Header().Get("Allow"), ShouldEqual, "GET, POST")
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Users::Id#methodNotAllowed")
+		So(receiveAction(), ShouldEqual, "Users::Id#methodNotAllowed")
+	})
+}
@@ -0,0 +1,124 @@
+package clickhouse
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/xing/logjam-agent-go"
+)
+
+// Wrap returns a connection recording the time spent on queries, inserts and batches as
+// clickhouse_time, their number as clickhouse_calls and the number of rows selected or
+// sent in batches as clickhouse_rows on the logjam request of the operation context.
+// Queries are timed until the first block of results arrived; rows are counted when the
+// rows are closed.
+func Wrap(conn driver.Conn) driver.Conn {
+	return &wrappedConn{Conn: conn}
+}
+
+type wrappedConn struct {
+	driver.Conn
+}
+
+// record adds an operation which started at the given time to the logjam request of the
+// context and returns the request, or nil if the context has none.
+func record(ctx context.Context, start time.Time) *logjam.Request {
+	request := logjam.GetRequest(ctx)
+	if request != nil {
+		request.AddDuration("clickhouse_time", time.Since(start))
+		request.Count("clickhouse_calls")
+	}
+	return request
+}
+
+func (c *wrappedConn) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	start := time.Now()
+	err := c.Conn.Select(ctx, dest, query, args...)
+	if request := record(ctx, start); request != nil && err == nil {
+		if v := reflect.Indirect(reflect.ValueOf(dest)); v.Kind() == reflect.Slice {
+			request.AddCount("clickhouse_rows", int64(v.Len()))
+		}
+	}
+	return err
+}
+
+func (c *wrappedConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := c.Conn.Query(ctx, query, args...)
+	request := record(ctx, start)
+	if request == nil || err != nil {
+		return rows, err
+	}
+	return &countingRows{Rows: rows, request: request}, nil
+}
+
+func (c *wrappedConn) QueryRow(ctx context.Context, query string, args ...interface{}) driver.Row {
+	start := time.Now()
+	row := c.Conn.QueryRow(ctx, query, args...)
+	record(ctx, start)
+	return row
+}
+
+func (c *wrappedConn) Exec(ctx context.Context, query string, args ...interface{}) error {
+	start := time.Now()
+	err := c.Conn.Exec(ctx, query, args...)
+	record(ctx, start)
+	return err
+}
+
+func (c *wrappedConn) AsyncInsert(ctx context.Context, query string, wait bool, args ...interface{}) error {
+	start := time.Now()
+	err := c.Conn.AsyncInsert(ctx, query, wait, args...)
+	record(ctx, start)
+	return err
+}
+
+func (c *wrappedConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	batch, err := c.Conn.PrepareBatch(ctx, query, opts...)
+	if err != nil || logjam.GetRequest(ctx) == nil {
+		return batch, err
+	}
+	return &recordingBatch{Batch: batch, ctx: ctx}, nil
+}
+
+// countingRows counts the rows read and adds them to the logjam request when closed.
+type countingRows struct {
+	driver.Rows
+	request *logjam.Request
+	n       int64
+	closed  bool
+}
+
+func (r *countingRows) Next() bool {
+	if r.Rows.Next() {
+		r.n++
+		return true
+	}
+	return false
+}
+
+func (r *countingRows) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.request.AddCount("clickhouse_rows", r.n)
+	}
+	return r.Rows.Close()
+}
+
+// recordingBatch records sending the batch.
+type recordingBatch struct {
+	driver.Batch
+	ctx context.Context
+}
+
+func (b *recordingBatch) Send() error {
+	rows := b.Batch.Rows()
+	start := time.Now()
+	err := b.Batch.Send()
+	if request := record(b.ctx, start); request != nil && err == nil {
+		request.AddCount("clickhouse_rows", int64(rows))
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package clickhouse
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+// fakeConn returns three rows for every query. Other methods aren't implemented.
+type fakeConn struct {
+	driver.Conn
+}
+
+func (fakeConn) Select(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	*dest.(*[]int) = []int{1, 2, 3}
+	return nil
+}
+
+func (fakeConn) Query(ctx context.Context, query string, args ...interface{}) (driver.Rows, error) {
+	return &fakeRows{n: 3}, nil
+}
+
+func (fakeConn) Exec(ctx context.Context, query string, args ...interface{}) error {
+	return nil
+}
+
+func (fakeConn) PrepareBatch(ctx context.Context, query string, opts ...driver.PrepareBatchOption) (driver.Batch, error) {
+	return &fakeBatch{}, nil
+}
+
+type fakeRows struct {
+	driver.Rows
+	n int
+}
+
+func (r *fakeRows) Next() bool {
+	r.n--
+	return r.n >= 0
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+type fakeBatch struct {
+	driver.Batch
+	rows int
+}
+
+func (b *fakeBatch) Append(v ...interface{}) error {
+	b.rows++
+	return nil
+}
+
+func (b *fakeBatch) Rows() int   { return b.rows }
+func (b *fakeBatch) Send() error { return nil }
+
+func TestWrap(t *testing.T) {
+	collector := logjamtest.NewCollector("clickhouse-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+	conn := Wrap(fakeConn{})
+
+	Convey("recording operations and rows", t, func() {
+		request := agent.NewRequest("Reports#show")
+		ctx := request.NewContext(context.Background())
+
+		var ids []int
+		So(conn.Select(ctx, &ids, "SELECT id FROM events"), ShouldBeNil)
+		rows, err := conn.Query(ctx, "SELECT id FROM events")
+		So(err, ShouldBeNil)
+		for rows.Next() {
+		}
+		So(rows.Close(), ShouldBeNil)
+		So(conn.Exec(ctx, "OPTIMIZE TABLE events"), ShouldBeNil)
+		batch, err := conn.PrepareBatch(ctx, "INSERT INTO events")
+		So(err, ShouldBeNil)
+		batch.Append(1)
+		batch.Append(2)
+		So(batch.Send(), ShouldBeNil)
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output, ShouldContainKey, "clickhouse_time")
+		So(output["clickhouse_calls"], ShouldEqual, 4)
+		So(output["clickhouse_rows"], ShouldEqual, 8)
+	})
+}
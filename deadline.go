@@ -0,0 +1,42 @@
+package logjam
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const budgetExceededException = "budget_exceeded"
+
+// WithBudget derives a context for outgoing calls from the given context. Its deadline is
+// the start time of the logjam request found in the context plus the given latency budget,
+// so that all calls made on behalf of the request share the same budget. Calling the
+// returned cancel function after the call has completed adds the exception tag
+// budget_exceeded to the request if the deadline has passed by then, tying the timing
+// data sent to logjam to the timeout policy of the service. Without a logjam request, the
+// budget starts now and no exception is recorded.
+func WithBudget(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	request := GetRequest(ctx)
+	if request == nil {
+		return context.WithTimeout(ctx, budget)
+	}
+	deadline := request.Deadline(budget)
+	derived, cancel := context.WithDeadline(ctx, deadline)
+	var once sync.Once
+	return derived, func() {
+		once.Do(func() {
+			if !time.Now().Before(deadline) {
+				request.AddException(budgetExceededException)
+			}
+			cancel()
+		})
+	}
+}
+
+// Deadline returns the point in time when the given latency budget for the request is used
+// up, measured from the start time of the request.
+func (r *Request) Deadline(budget time.Duration) time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.startTime.Add(budget)
+}
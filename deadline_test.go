@@ -0,0 +1,59 @@
+package logjam
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWithBudget(t *testing.T) {
+	agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+	defer agent.Shutdown()
+
+	Convey("deriving deadlines for outgoing calls", t, func() {
+		Convey("uses the start time of the request", func() {
+			start := time.Now().Add(-time.Second)
+			request := agent.NewRequestAt("Users#show", start)
+			ctx, cancel := WithBudget(request.NewContext(context.Background()), 2*time.Second)
+			deadline, ok := ctx.Deadline()
+			So(ok, ShouldBeTrue)
+			So(deadline, ShouldEqual, start.Add(2*time.Second))
+			So(ctx.Err(), ShouldBeNil)
+			cancel()
+			So(request.exceptions, ShouldBeEmpty)
+		})
+
+		Convey("records budget overruns as exception", func() {
+			request := agent.NewRequestAt("Users#show", time.Now().Add(-time.Second))
+			ctx, cancel := WithBudget(request.NewContext(context.Background()), 500*time.Millisecond)
+			So(errors.Is(ctx.Err(), context.DeadlineExceeded), ShouldBeTrue)
+			cancel()
+			cancel()
+			So(request.exceptions, ShouldResemble, map[string]int{"budget_exceeded": 1})
+		})
+
+		Convey("keeps an earlier deadline of the parent context", func() {
+			request := agent.NewRequest("Users#show")
+			parent, cancelParent := context.WithTimeout(request.NewContext(context.Background()), time.Millisecond)
+			defer cancelParent()
+			ctx, cancel := WithBudget(parent, time.Minute)
+			defer cancel()
+			parentDeadline, _ := parent.Deadline()
+			deadline, _ := ctx.Deadline()
+			So(deadline, ShouldEqual, parentDeadline)
+		})
+
+		Convey("starts the budget now without a request", func() {
+			ctx, cancel := WithBudget(context.Background(), time.Minute)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			So(ok, ShouldBeTrue)
+			So(deadline.After(time.Now().Add(59*time.Second)), ShouldBeTrue)
+		})
+	})
+}
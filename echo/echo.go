@@ -0,0 +1,92 @@
+package echo
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/xing/logjam-agent-go"
+)
+
+type contextKey int
+
+const echoCallKey contextKey = 0
+
+// echoCall carries the echo context and the next handler of a request through the logjam
+// handler, which is shared by all requests.
+type echoCall struct {
+	context echo.Context
+	next    echo.HandlerFunc
+}
+
+// Middleware returns an echo middleware sending echo requests to logjam. Install it using
+// e.Use(echo.Middleware(agent, options)). Action names are taken from route names which
+// look like logjam action names (i.e. contain a "#"), e.g. set with
+// e.GET("/users/:id", ...).Name = "Users#show". All other routes get an action name
+// derived from the route path. Errors returned by the handler chain are passed to
+// echo's HTTP error handler, so that logjam sees the response code written by it.
+func Middleware(agent *logjam.Agent, options logjam.MiddlewareOptions) echo.MiddlewareFunc {
+	names := &actionNames{}
+	h := agent.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := r.Context().Value(echoCallKey).(*echoCall)
+		c := call.context
+		c.SetRequest(r)
+		c.Response().Writer = w
+		if request := logjam.GetRequest(r.Context()); request != nil {
+			if path := c.Path(); path != "" {
+				request.SetRouteTemplate(path)
+				request.ChangeAction(w, names.lookup(c.Echo(), r.Method, path))
+			}
+		}
+		if err := call.next(c); err != nil {
+			c.Error(err)
+		}
+	}), options)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			call := &echoCall{context: c, next: next}
+			h.ServeHTTP(c.Response().Writer, r.WithContext(context.WithValue(r.Context(), echoCallKey, call)))
+			return nil
+		}
+	}
+}
+
+// GetRequest retrieves the logjam request from an echo context. Returns nil if the
+// request isn't handled by the logjam middleware.
+func GetRequest(c echo.Context) *logjam.Request {
+	return logjam.GetRequest(c.Request().Context())
+}
+
+// GetLogger retrieves the logger passed in the Logger middleware option from an echo
+// context. Returns a logger discarding all output if there is none.
+func GetLogger(c echo.Context) *logjam.Logger {
+	return logjam.LoggerFromContext(c.Request().Context())
+}
+
+// actionNames caches the action names of routes, as echo provides no way to get at the
+// matched route other than scanning all routes.
+type actionNames struct {
+	names sync.Map // method and path => action name
+}
+
+func (a *actionNames) lookup(e *echo.Echo, method, path string) string {
+	key := method + " " + path
+	if name, found := a.names.Load(key); found {
+		return name.(string)
+	}
+	name := actionName(e, method, path)
+	a.names.Store(key, name)
+	return name
+}
+
+func actionName(e *echo.Echo, method, path string) string {
+	for _, route := range e.Routes() {
+		if route.Method == method && route.Path == path && strings.Contains(route.Name, "#") {
+			return route.Name
+		}
+	}
+	return logjam.RouteTemplateActionName(method, path)
+}
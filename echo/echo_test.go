@@ -0,0 +1,69 @@
+package echo
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+)
+
+func TestMiddleware(t *testing.T) {
+	agent := logjam.NewAgent(&logjam.Options{Logger: log.New(ioutil.Discard, "", 0)})
+	codes := map[string]int{}
+	options := logjam.MiddlewareOptions{
+		BeforeFinish: func(r *http.Request, req *logjam.Request, code int) {
+			codes[r.URL.Path] = code
+			req.Ignore()
+		},
+	}
+
+	e := echo.New()
+	e.Use(Middleware(agent, options))
+	show := e.GET("/users/:id", func(c echo.Context) error {
+		if GetRequest(c) == nil {
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.String(http.StatusOK, "ok")
+	})
+	show.Name = "Users#show"
+	e.GET("/users/:id/friends", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusForbidden, "forbidden")
+	})
+
+	perform := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	Convey("using route names as action names", t, func() {
+		w := perform("/users/123")
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Users#show")
+		So(codes["/users/123"], ShouldEqual, http.StatusOK)
+	})
+
+	Convey("caching action names across requests", t, func() {
+		perform("/users/123")
+		show.Name = "Users#renamed"
+		defer func() { show.Name = "Users#show" }()
+		w := perform("/users/456")
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Users#show")
+	})
+
+	Convey("deriving action names from route paths", t, func() {
+		w := perform("/users/123/friends")
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Users::Id::Friends#get")
+	})
+
+	Convey("recording response codes written by the echo error handler", t, func() {
+		w := perform("/users/123/friends")
+		So(w.Code, ShouldEqual, http.StatusForbidden)
+		So(codes["/users/123/friends"], ShouldEqual, http.StatusForbidden)
+	})
+}
@@ -0,0 +1,122 @@
+package fasthttp
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"github.com/xing/logjam-agent-go"
+)
+
+type contextKey int
+
+const (
+	writerKey contextKey = iota // stores the response writer in the http request context
+	stateKey                    // stores the state in the fasthttp request context
+)
+
+// state provides fasthttp handlers access to the logjam request.
+type state struct {
+	ctx context.Context     // the context of the converted http request
+	w   http.ResponseWriter // the response writer seen by the logjam middleware
+}
+
+// NewHandler wraps a fasthttp request handler with the logjam middleware. Requests are
+// converted to http requests for the middleware, so action name extraction, call and
+// trace headers, panic handling and all other middleware options work the same way as
+// for net/http handlers. The converted request doesn't reach the handler though, which
+// gets the original fasthttp request context.
+func NewHandler(agent *logjam.Agent, handler fasthttp.RequestHandler, options logjam.MiddlewareOptions) fasthttp.RequestHandler {
+	h := agent.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := r.Context().Value(writerKey).(*responseWriter)
+		ctx := rw.ctx
+		ctx.SetUserValue(stateKey, &state{ctx: r.Context(), w: w})
+		handler(ctx)
+		// Report the response written by the handler to the middleware.
+		rw.mirror = true
+		if contentType := ctx.Response.Header.ContentType(); len(contentType) > 0 {
+			w.Header().Set("Content-Type", string(contentType))
+		}
+		w.WriteHeader(ctx.Response.StatusCode())
+		if !ctx.Response.IsBodyStream() {
+			w.Write(ctx.Response.Body())
+		}
+	}), options)
+	return func(ctx *fasthttp.RequestCtx) {
+		var r http.Request
+		if err := fasthttpadaptor.ConvertRequest(ctx, &r, true); err != nil {
+			ctx.Error(http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		rw := &responseWriter{ctx: ctx, header: http.Header{}}
+		h.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), writerKey, rw)))
+	}
+}
+
+// GetRequest retrieves the logjam request from a fasthttp request context. Returns nil if
+// the request isn't handled by the logjam middleware.
+func GetRequest(ctx *fasthttp.RequestCtx) *logjam.Request {
+	if s, ok := ctx.UserValue(stateKey).(*state); ok {
+		return logjam.GetRequest(s.ctx)
+	}
+	return nil
+}
+
+// Context returns a context holding the logjam request of a fasthttp request context, for
+// use with the logjam logger and logjam.SetCallHeaders.
+func Context(ctx *fasthttp.RequestCtx) context.Context {
+	if s, ok := ctx.UserValue(stateKey).(*state); ok {
+		return s.ctx
+	}
+	return ctx
+}
+
+// ChangeAction changes the action name of the logjam request of a fasthttp request
+// context and updates the X-Logjam-Action response header.
+func ChangeAction(ctx *fasthttp.RequestCtx, action string) {
+	if s, ok := ctx.UserValue(stateKey).(*state); ok {
+		logjam.ChangeAction(s.ctx, s.w, action)
+	}
+}
+
+// responseWriter passes everything written by the logjam middleware, like headers and
+// panic responses, on to the fasthttp response. Once mirroring the response written by
+// the fasthttp handler, the body is only counted by the middleware.
+type responseWriter struct {
+	ctx         *fasthttp.RequestCtx // the fasthttp request context
+	header      http.Header          // the headers set by the middleware
+	wroteHeader bool                 // whether headers were copied to the fasthttp response
+	mirror      bool                 // whether the fasthttp handler has written the response
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	for name, values := range w.header {
+		for i, value := range values {
+			if i == 0 {
+				w.ctx.Response.Header.Set(name, value)
+			} else {
+				w.ctx.Response.Header.Add(name, value)
+			}
+		}
+	}
+	w.ctx.SetStatusCode(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.mirror {
+		return len(p), nil
+	}
+	return w.ctx.Write(p)
+}
@@ -0,0 +1,72 @@
+package fasthttp
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/valyala/fasthttp"
+	"github.com/xing/logjam-agent-go"
+)
+
+func TestNewHandler(t *testing.T) {
+	agent := logjam.NewAgent(&logjam.Options{Logger: log.New(ioutil.Discard, "", 0)})
+	codes := map[string]int{}
+	options := logjam.MiddlewareOptions{
+		BeforeFinish: func(r *http.Request, req *logjam.Request, code int) {
+			codes[r.URL.Path] = code
+			req.Ignore()
+		},
+	}
+
+	handler := NewHandler(agent, func(ctx *fasthttp.RequestCtx) {
+		switch string(ctx.Request.RequestURI()) {
+		case "/panic":
+			panic("boom")
+		case "/users/123":
+			if GetRequest(ctx) != nil {
+				ChangeAction(ctx, "Users#show")
+			}
+			ctx.SetStatusCode(http.StatusCreated)
+			ctx.WriteString("created")
+		}
+	}, options)
+
+	perform := func(method, uri string, headers map[string]string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		for name, value := range headers {
+			ctx.Request.Header.Set(name, value)
+		}
+		handler(ctx)
+		return ctx
+	}
+
+	Convey("handling requests", t, func() {
+		ctx := perform("GET", "/users/123", nil)
+		So(ctx.Response.StatusCode(), ShouldEqual, http.StatusCreated)
+		So(string(ctx.Response.Body()), ShouldEqual, "created")
+		So(string(ctx.Response.Header.Peek("X-Logjam-Action")), ShouldEqual, "Users#show")
+		So(string(ctx.Response.Header.Peek("X-Logjam-Request-Id")), ShouldNotBeEmpty)
+		So(codes["/users/123"], ShouldEqual, http.StatusCreated)
+	})
+
+	Convey("extracting action names", t, func() {
+		ctx := perform("GET", "/groups/123", nil)
+		So(string(ctx.Response.Header.Peek("X-Logjam-Action")), ShouldEqual, "Groups::Id#get")
+	})
+
+	Convey("propagating trace ids", t, func() {
+		ctx := perform("GET", "/groups/123", map[string]string{"X-Logjam-Trace-Id": "4bf92f3577b34da6a3ce929d0e0e4736"})
+		So(string(ctx.Response.Header.Peek("X-Logjam-Trace-Id")), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+	})
+
+	Convey("capturing panics", t, func() {
+		ctx := perform("GET", "/panic", nil)
+		So(ctx.Response.StatusCode(), ShouldEqual, http.StatusInternalServerError)
+		So(codes["/panic"], ShouldEqual, http.StatusInternalServerError)
+	})
+}
@@ -1,13 +1,18 @@
 module github.com/xing/logjam-agent-go
 
-go 1.11
+go 1.22
 
 require (
-	github.com/felixge/httpsnoop v1.0.1
+	github.com/felixge/httpsnoop v1.0.3
 	github.com/golang/snappy v0.0.1
-	github.com/gorilla/context v1.1.1 // indirect
-	github.com/gorilla/handlers v1.4.2
 	github.com/gorilla/mux v1.6.2
 	github.com/pebbe/zmq4 v1.2.0
 	github.com/smartystreets/goconvey v1.6.4
 )
+
+require (
+	github.com/gopherjs/gopherjs v0.0.0-20181017120253-0766667cb4d1 // indirect
+	github.com/gorilla/context v1.1.1 // indirect
+	github.com/jtolds/gls v4.20.0+incompatible // indirect
+	github.com/smartystreets/assertions v0.0.0-20180927180507-b2de0cb4f26d // indirect
+)
@@ -0,0 +1,40 @@
+package goa
+
+import (
+	"context"
+	"strings"
+
+	"github.com/xing/logjam-agent-go"
+	goa "goa.design/goa/v3/pkg"
+)
+
+// Endpoint is a goa endpoint middleware naming the logjam request after the goa service
+// and method from the design, e.g. "UserProfiles#show" for the method "show" of the
+// service "user_profiles". The logjam request is taken from the endpoint context, so the
+// HTTP handler generated by goa must be wrapped with the logjam middleware. Install it
+// on the generated endpoints using endpoints.Use(goa.Endpoint).
+func Endpoint(endpoint goa.Endpoint) goa.Endpoint {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		service, _ := ctx.Value(goa.ServiceKey).(string)
+		method, _ := ctx.Value(goa.MethodKey).(string)
+		if method != "" {
+			logjam.SetAction(ctx, actionName(service, method))
+		}
+		return endpoint(ctx, req)
+	}
+}
+
+func actionName(service, method string) string {
+	class := "Unknown"
+	if service != "" {
+		class = ""
+		for _, part := range strings.FieldsFunc(service, isSeparator) {
+			class += strings.Title(part)
+		}
+	}
+	return class + "#" + strings.Join(strings.FieldsFunc(method, isSeparator), "_")
+}
+
+func isSeparator(r rune) bool {
+	return r == '_' || r == '-' || r == ' '
+}
@@ -0,0 +1,45 @@
+package goa
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+	goa "goa.design/goa/v3/pkg"
+)
+
+func TestActionName(t *testing.T) {
+	Convey("deriving action names from goa services and methods", t, func() {
+		So(actionName("users", "show"), ShouldEqual, "Users#show")
+		So(actionName("user_profiles", "list all"), ShouldEqual, "UserProfiles#list_all")
+		So(actionName("user-profiles", "show"), ShouldEqual, "UserProfiles#show")
+		So(actionName("", "show"), ShouldEqual, "Unknown#show")
+	})
+}
+
+func TestEndpoint(t *testing.T) {
+	collector := logjamtest.NewCollector("goa-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("naming requests served by goa endpoints", t, func() {
+		request := agent.NewRequest("Unknown#post")
+		ctx := request.NewContext(context.Background())
+		ctx = context.WithValue(ctx, goa.ServiceKey, "users")
+		ctx = context.WithValue(ctx, goa.MethodKey, "create")
+
+		endpoint := Endpoint(func(ctx context.Context, req interface{}) (interface{}, error) {
+			So(logjam.GetRequest(ctx), ShouldEqual, request)
+			return "created", nil
+		})
+		res, err := endpoint(ctx, nil)
+		So(err, ShouldBeNil)
+		So(res, ShouldEqual, "created")
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output["action"], ShouldEqual, "Users#create")
+	})
+}
@@ -0,0 +1,78 @@
+package gocql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gocql/gocql"
+	"github.com/xing/logjam-agent-go"
+)
+
+// tablePattern extracts the table name from CQL statements.
+var tablePattern = regexp.MustCompile(`(?i)\b(?:from|into|update)\s+("?[\w.]+"?)`)
+
+// Observer implements gocql.QueryObserver and gocql.BatchObserver, recording the time spent
+// on queries and batches as cassandra_time and their number as cassandra_calls on the
+// logjam request of the query context. Every attempt of a retried query counts as a call.
+// Timeouts are logged as WARN lines naming the keyspace and table. Install it using
+// cluster.QueryObserver = gocql.Observer{} and cluster.BatchObserver = gocql.Observer{}.
+type Observer struct{}
+
+var (
+	_ gocql.QueryObserver = Observer{}
+	_ gocql.BatchObserver = Observer{}
+)
+
+// ObserveQuery implements gocql.QueryObserver.
+func (Observer) ObserveQuery(ctx context.Context, q gocql.ObservedQuery) {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return
+	}
+	request.AddDuration("cassandra_time", q.End.Sub(q.Start))
+	request.Count("cassandra_calls")
+	if isTimeout(q.Err) {
+		request.Log(logjam.WARN, fmt.Sprintf("cassandra query timed out on %s: %v", tableName(q.Keyspace, q.Statement), q.Err))
+	}
+}
+
+// ObserveBatch implements gocql.BatchObserver.
+func (Observer) ObserveBatch(ctx context.Context, b gocql.ObservedBatch) {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return
+	}
+	request.AddDuration("cassandra_time", b.End.Sub(b.Start))
+	request.Count("cassandra_calls")
+	if isTimeout(b.Err) {
+		tables := make([]string, 0, len(b.Statements))
+		for _, statement := range b.Statements {
+			tables = append(tables, tableName(b.Keyspace, statement))
+		}
+		request.Log(logjam.WARN, fmt.Sprintf("cassandra batch timed out on %s: %v", strings.Join(tables, ", "), b.Err))
+	}
+}
+
+func isTimeout(err error) bool {
+	switch err.(type) {
+	case *gocql.RequestErrReadTimeout, *gocql.RequestErrWriteTimeout:
+		return true
+	}
+	return err == gocql.ErrTimeoutNoResponse
+}
+
+// tableName returns the table of the statement qualified with the keyspace, unless the
+// statement qualifies it already.
+func tableName(keyspace, statement string) string {
+	match := tablePattern.FindStringSubmatch(statement)
+	if match == nil {
+		return keyspace
+	}
+	table := strings.Replace(match[1], `"`, "", -1)
+	if strings.Contains(table, ".") || keyspace == "" {
+		return table
+	}
+	return keyspace + "." + table
+}
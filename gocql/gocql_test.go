@@ -0,0 +1,55 @@
+package gocql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestTableName(t *testing.T) {
+	Convey("extracting table names", t, func() {
+		So(tableName("users", "SELECT * FROM profiles WHERE id = ?"), ShouldEqual, "users.profiles")
+		So(tableName("users", "insert into events.log (id) values (?)"), ShouldEqual, "events.log")
+		So(tableName("users", `UPDATE "Profiles" SET name = ?`), ShouldEqual, "users.Profiles")
+		So(tableName("users", "TRUNCATE profiles"), ShouldEqual, "users")
+	})
+}
+
+func TestObserver(t *testing.T) {
+	collector := logjamtest.NewCollector("gocql-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("recording queries and batches", t, func() {
+		start := time.Now()
+		request := agent.NewRequestAt("Users#show", start)
+		ctx := request.NewContext(context.Background())
+
+		Observer{}.ObserveQuery(ctx, gocql.ObservedQuery{
+			Keyspace: "users", Statement: "SELECT * FROM profiles", Start: start, End: start.Add(time.Millisecond),
+		})
+		Observer{}.ObserveQuery(ctx, gocql.ObservedQuery{
+			Keyspace: "users", Statement: "SELECT * FROM profiles", Start: start, End: start.Add(time.Millisecond),
+			Err: &gocql.RequestErrReadTimeout{},
+		})
+		Observer{}.ObserveBatch(ctx, gocql.ObservedBatch{
+			Keyspace: "users", Statements: []string{"INSERT INTO profiles (id) VALUES (?)"},
+			Start: start, End: start.Add(time.Millisecond), Err: errors.New("unavailable"),
+		})
+		time.Sleep(5 * time.Millisecond)
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output["cassandra_time"], ShouldEqual, 3.0)
+		So(output["cassandra_calls"], ShouldEqual, 3)
+		lines := output["lines"].([]interface{})
+		So(lines, ShouldHaveLength, 1)
+		So(lines[0].([]interface{})[2], ShouldStartWith, "cassandra query timed out on users.profiles: ")
+	})
+}
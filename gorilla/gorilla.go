@@ -1,7 +1,9 @@
 package gorilla
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"sort"
 	"strings"
@@ -15,13 +17,24 @@ import (
 type handler struct {
 	action       string       // the precomputed action name
 	appendMethod bool         // whether the action needs to be augmented by the HTTP request method in lowercase
+	nested       bool         // whether the action has been nested under the path prefix of its subrouter
+	ignore       bool         // whether requests for the route are not sent to logjam
+	sampleRate   float64      // the fraction of successful requests sent to logjam (if set)
 	handler      http.Handler // the original handler
 }
 
 func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	request := logjam.GetRequest(r.Context())
 	if request != nil {
-		request.ChangeAction(w, h.actionName(r.Method))
+		if h.action != "" {
+			request.ChangeAction(w, h.actionName(r.Method))
+		}
+		if h.ignore {
+			request.Ignore()
+		}
+		if h.sampleRate > 0 {
+			request.SetSampleRate(h.sampleRate)
+		}
 	}
 	h.handler.ServeHTTP(w, r)
 }
@@ -34,52 +47,202 @@ func (h *handler) actionName(method string) string {
 }
 
 // ActionName registers a logjam action name for the given mux route. Requires a logjam
-// compatible action name of the form (Module::)*Controller#action.
+// compatible action name of the form (Module::)*Controller#action. Action names without
+// "#" get the HTTP request method appended and, once SetupRoutes has been called, are
+// nested under the path prefix of their subrouter, i.e. "Users" on a subrouter created
+// with PathPrefix("/api/v2") becomes "Api::V2::Users#get".
 func ActionName(route *mux.Route, actionName string) {
-	route.Handler(handler{
-		action:       actionName,
-		appendMethod: !strings.Contains(actionName, "#"),
-		handler:      route.GetHandler(),
+	updateHandler(route, func(h *handler) {
+		h.action = actionName
+		h.appendMethod = !strings.Contains(actionName, "#")
+		h.nested = false
+	})
+}
+
+// Ignore makes the logjam middleware ignore requests for the given mux route, e.g. for
+// health checks.
+func Ignore(route *mux.Route) {
+	updateHandler(route, func(h *handler) {
+		h.ignore = true
 	})
 }
 
+// Sample makes the logjam middleware send only the given fraction of successful requests
+// for the given mux route to logjam, overriding the sample rates of the middleware.
+func Sample(route *mux.Route, rate float64) {
+	updateHandler(route, func(h *handler) {
+		h.sampleRate = rate
+	})
+}
+
+// updateHandler updates the logjam handler of the given route, installing one if the
+// route has none yet.
+func updateHandler(route *mux.Route, update func(h *handler)) {
+	h, isLogjamHandler := route.GetHandler().(handler)
+	if !isLogjamHandler {
+		h = handler{handler: route.GetHandler()}
+	}
+	update(&h)
+	route.Handler(h)
+}
+
+// RouteTemplates holds the templates of a mux route from which action names are derived.
+type RouteTemplates struct {
+	Host    string   // the host template, e.g. "{tenant}.example.com"
+	Path    string   // the path template, e.g. "/users/{user_id:[0-9]+}"
+	Queries []string // the query templates, e.g. "type=admin"
+}
+
+// ActionNameFormatter converts route templates to a logjam action name. The HTTP request
+// method in lowercase gets appended to action names without a "#". Returning an empty
+// action name leaves the route without a logjam action name.
+type ActionNameFormatter func(RouteTemplates) string
+
 // SetupRoutes traverses all routes of the given router and replaces handlers which have
 // no logjam action name attached yet with a new handler that uses an action name
-// derived from the path template. It must be called after all routes have been set up
-// on the router.
+// derived from the route templates by DefaultActionNameFormatter. It must be called
+// after all routes have been set up on the router.
 func SetupRoutes(r *mux.Router) {
+	SetupRoutesWithFormatter(r, DefaultActionNameFormatter)
+}
+
+// SetupRoutesWithFormatter is like SetupRoutes, but uses the given formatter to derive
+// action names from the route templates.
+func SetupRoutesWithFormatter(r *mux.Router, format ActionNameFormatter) {
 	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 		h := route.GetHandler()
 		if h == nil {
 			return nil
 		}
-		if _, isLogjamHandler := h.(handler); isLogjamHandler {
-			return nil
+		lh, isLogjamHandler := h.(handler)
+		if !isLogjamHandler {
+			lh = handler{handler: h}
 		}
-		action, appendMethod := actionName(route)
-		if action == "" {
+		switch {
+		case lh.action == "":
+			action := format(routeTemplates(route))
+			if action == "" {
+				return nil
+			}
+			lh.action = action
+			lh.appendMethod = !strings.Contains(action, "#")
+			lh.nested = true
+		case lh.appendMethod && !lh.nested:
+			lh.action = nest(lh.action, ancestors)
+			lh.nested = true
+		default:
 			return nil
 		}
-		route.Handler(handler{
-			action:       action,
-			appendMethod: appendMethod,
-			handler:      h,
-		})
+		route.Handler(lh)
 		return nil
 	})
 }
 
-func actionName(route *mux.Route) (string, bool) {
-	template, _ := route.GetPathTemplate()
-	if template == "" {
-		return "", false
+// nest prefixes an action name with the modules derived from the path prefix of the
+// innermost subrouter, unless the action name already starts with them.
+func nest(action string, ancestors []*mux.Route) string {
+	if len(ancestors) == 0 {
+		return action
 	}
-	parts, appendMethod := actionNameParts(template)
+	prefix, _ := ancestors[len(ancestors)-1].GetPathTemplate()
+	modules := []string{}
+	for _, segment := range strings.Split(stripPatterns(prefix), "/") {
+		if segment == "" || strings.Contains(segment, "{") {
+			continue
+		}
+		modules = append(modules, formatSegment(segment))
+	}
+	if len(modules) == 0 {
+		return action
+	}
+	module := strings.Join(modules, "::")
+	if action == module || strings.HasPrefix(action, module+"::") {
+		return action
+	}
+	return module + "::" + action
+}
+
+func routeTemplates(route *mux.Route) RouteTemplates {
+	host, _ := route.GetHostTemplate()
+	path, _ := route.GetPathTemplate()
+	queries, _ := route.GetQueriesTemplates()
+	return RouteTemplates{Host: host, Path: path, Queries: queries}
+}
+
+// DefaultActionNameFormatter derives action names from the path template, or the host
+// template for routes without one. Path segments are camel cased and joined with "::",
+// variables are dropped. A literal last segment following a variable becomes the action,
+// e.g. "/users/{user_id}/friends" results in "Users#friends". Query matchers with literal
+// values are added as further segments, so "/search" with the query "type=admin" becomes
+// "Search::Type::Admin".
+func DefaultActionNameFormatter(t RouteTemplates) string {
+	var parts []string
+	appendMethod := true
+	switch {
+	case t.Path != "":
+		parts, appendMethod = actionNameParts(stripPatterns(t.Path))
+	case t.Host != "":
+		parts = hostParts(stripPatterns(t.Host))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	queryParts := queriesParts(t.Queries)
 	if appendMethod {
-		return strings.Join(parts, "::"), true
+		return strings.Join(append(parts, queryParts...), "::")
+	}
+	n := len(parts) - 1
+	return strings.Join(append(parts[0:n:n], queryParts...), "::") + "#" + parts[n]
+}
+
+// stripPatterns removes the regular expressions from the variables of a template, as
+// they may contain slashes, dots or braces: "/{path:[a-z]{2}/.*}" becomes "/{path}".
+func stripPatterns(template string) string {
+	var b strings.Builder
+	depth, skipping := 0, false
+	for _, c := range template {
+		switch {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				skipping = false
+			}
+		case c == ':' && depth == 1:
+			skipping = true
+		}
+		if !skipping {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func hostParts(host string) []string {
+	parts := []string{}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || strings.Contains(label, "{") {
+			continue
+		}
+		parts = append(parts, formatSegment(label))
+	}
+	return parts
+}
+
+func queriesParts(queries []string) []string {
+	parts := []string{}
+	for _, query := range queries {
+		kv := strings.SplitN(query, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || strings.Contains(query, "{") {
+			continue
+		}
+		parts = append(parts, formatSegment(kv[0]))
+		if kv[1] != "" {
+			parts = append(parts, formatSegment(kv[1]))
+		}
 	}
-	action := strings.Join(parts[0:len(parts)-1], "::") + "#" + parts[len(parts)-1]
-	return action, false
+	return parts
 }
 
 func formatSegment(s string) string {
@@ -104,7 +267,7 @@ func actionNameParts(path string) ([]string, bool) {
 		if part == "" {
 			continue
 		}
-		if strings.HasPrefix(part, "{") {
+		if strings.Contains(part, "{") {
 			if i < n {
 				lastSegmentWasPattern = true
 				continue
@@ -123,60 +286,106 @@ func actionNameParts(path string) ([]string, bool) {
 	return parts, true
 }
 
+// RouteTemplate is a mux middleware recording the path template of the matched route on
+// the logjam request, so that the logjam middleware can derive action names for routes
+// without a logjam action name. Install it using router.Use(gorilla.RouteTemplate).
+func RouteTemplate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if request := logjam.GetRequest(r.Context()); request != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					request.SetRouteTemplate(template)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // routeInfo is just for printing routes
 type routeInfo struct {
 	route   *mux.Route // the corresponding route
 	handler handler    // the logjam handler
 }
 
-// PrintRoutes prints the routes and their logjam action names.
-func PrintRoutes(r *mux.Router) {
-	routes := []routeInfo{}
+// Route describes a mux route with a logjam action name.
+type Route struct {
+	Method     string `json:"method"`      // the HTTP method, ALL for routes matching any method
+	Path       string `json:"path"`        // the path template
+	Action     string `json:"action"`      // the logjam action name
+	NotAllowed bool   `json:"not_allowed"` // whether the route answers requests with methods not allowed for the path
+}
+
+// Routes returns the routes with logjam action names, sorted by path template. Routes
+// matching several methods are listed once for each method.
+func Routes(r *mux.Router) []Route {
+	infos := []routeInfo{}
 	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 		h := route.GetHandler()
 		if h == nil {
 			return nil
 		}
-		if lh, isLogjamHandler := h.(handler); isLogjamHandler {
-			routes = append(routes, routeInfo{route: route, handler: lh})
+		if lh, isLogjamHandler := h.(handler); isLogjamHandler && lh.action != "" {
+			infos = append(infos, routeInfo{route: route, handler: lh})
 		}
 		return nil
 	})
-	sortRoutes(routes)
-	printRoutes(routes)
+	sortRoutes(infos)
+	routes := []Route{}
+	for _, info := range infos {
+		template, _ := info.route.GetPathTemplate()
+		methods, _ := info.route.GetMethods()
+		if len(methods) == 0 {
+			routes = append(routes, newRoute("ALL", template, info.handler.actionName(":method")))
+			continue
+		}
+		for _, m := range methods {
+			routes = append(routes, newRoute(m, template, info.handler.actionName(m)))
+		}
+	}
+	return routes
+}
+
+func newRoute(method, path, action string) Route {
+	return Route{
+		Method:     method,
+		Path:       path,
+		Action:     action,
+		NotAllowed: strings.HasSuffix(action, "#methodNotAllowed"),
+	}
+}
+
+// WriteRoutesJSON writes the routes returned by Routes as JSON array to the given writer.
+func WriteRoutesJSON(w io.Writer, r *mux.Router) error {
+	return json.NewEncoder(w).Encode(Routes(r))
+}
+
+// PrintRoutes prints the routes and their logjam action names.
+func PrintRoutes(r *mux.Router) {
+	printRoutes(Routes(r))
 }
 
 func sortRoutes(routes []routeInfo) {
-	sort.Slice(routes, func(i, j int) bool {
+	sort.SliceStable(routes, func(i, j int) bool {
 		a, _ := routes[i].route.GetPathTemplate()
 		b, _ := routes[j].route.GetPathTemplate()
 		return a < b
 	})
 }
 
-func printRoutes(routes []routeInfo) {
+func printRoutes(routes []Route) {
 	n := maxRouteLength(routes)
 	fmt.Printf("\n============================ logjam routes ================================\n")
 	for _, r := range routes {
-		methods, _ := r.route.GetMethods()
-		if len(methods) == 0 {
-			template, _ := r.route.GetPathTemplate()
-			fmt.Printf("%-10s  %s  %s\n", "ALL", padRight(template, n), r.handler.actionName(":method"))
-			continue
-		}
-		for _, m := range methods {
-			template, _ := r.route.GetPathTemplate()
-			fmt.Printf("%-10s  %s  %s\n", m, padRight(template, n), r.handler.actionName(m))
-		}
+		fmt.Printf("%-10s  %s  %s\n", r.Method, padRight(r.Path, n), r.Action)
 	}
 }
 
-func maxRouteLength(routes []routeInfo) int {
+func maxRouteLength(routes []Route) int {
 	l := 0
 	for _, r := range routes {
-		template, _ := r.route.GetPathTemplate()
-		if len(template) > l {
-			l = len(template)
+		if len(r.Path) > l {
+			l = len(r.Path)
 		}
 	}
 	return l
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/golang/snappy"
@@ -105,3 +106,192 @@ func TestGorillaNameExtraction(t *testing.T) {
 
 	})
 }
+
+func TestRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(RouteTemplate)
+	router.Path("/users/{user_id}/friends").Methods("GET").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	socket, err := zmq4.NewSocket(zmq4.ROUTER)
+	if err != nil {
+		panic("cannot create socket for testing")
+	}
+	err = socket.Bind("inproc://gorilla-route-template-test")
+	if err != nil {
+		panic("cannot bind socket for testing")
+	}
+	defer socket.Close()
+
+	agent := logjam.NewAgent(&logjam.Options{
+		Endpoints:           "inproc://gorilla-route-template-test",
+		Logger:              log.New(ioutil.Discard, "", 0),
+		ActionNameExtractor: func(r *http.Request) string { return "Unknown#get" },
+	})
+	defer agent.Shutdown()
+
+	server := httptest.NewServer(agent.NewHandler(router, logjam.MiddlewareOptions{}))
+	defer server.Close()
+
+	Convey("deriving action names from route templates", t, func() {
+		res, err := server.Client().Get(server.URL + "/users/123/friends")
+		So(err, ShouldBeNil)
+		So(res.StatusCode, ShouldEqual, 200)
+
+		msg, err := socket.RecvMessage(0)
+		So(err, ShouldBeNil)
+		payload, err := snappy.Decode(nil, []byte(msg[3]))
+		So(err, ShouldBeNil)
+		output := map[string]interface{}{}
+		json.Unmarshal(payload, &output)
+		So(output["action"], ShouldEqual, "Users::Id::Friends#get")
+	})
+}
+
+func TestDefaultActionNameFormatter(t *testing.T) {
+	Convey("deriving action names from route templates", t, func() {
+		So(DefaultActionNameFormatter(RouteTemplates{Path: "/users/{user_id}/friends"}), ShouldEqual, "Users#friends")
+		So(DefaultActionNameFormatter(RouteTemplates{Path: "/users/{user_id:[0-9]+}"}), ShouldEqual, "Users")
+		So(DefaultActionNameFormatter(RouteTemplates{Path: "/files/{path:[a-z]{2}/.*}"}), ShouldEqual, "Files")
+		So(DefaultActionNameFormatter(RouteTemplates{Path: "/reports/{name}.pdf"}), ShouldEqual, "Reports")
+		So(DefaultActionNameFormatter(RouteTemplates{Path: "/search", Queries: []string{"type=admin", "q={q}"}}), ShouldEqual, "Search::Type::Admin")
+		So(DefaultActionNameFormatter(RouteTemplates{Path: "/users/{user_id}/friends", Queries: []string{"sort={order}", "page=1"}}), ShouldEqual, "Users::Page::1#friends")
+		So(DefaultActionNameFormatter(RouteTemplates{Host: "{tenant}.admin.example.com"}), ShouldEqual, "Admin::Example::Com")
+		So(DefaultActionNameFormatter(RouteTemplates{Path: "/"}), ShouldEqual, "")
+	})
+}
+
+func TestSetupRoutesWithFormatter(t *testing.T) {
+	router := mux.NewRouter()
+	router.Path("/users/{user_id:[0-9]+}").Methods("GET").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	router.Host("{tenant}.example.com").Methods("GET").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	SetupRoutesWithFormatter(router, func(t RouteTemplates) string {
+		if t.Path == "" {
+			return "Tenants#show"
+		}
+		return "Custom::" + DefaultActionNameFormatter(t)
+	})
+
+	agent := logjam.NewAgent(&logjam.Options{Logger: log.New(ioutil.Discard, "", 0)})
+	handler := agent.NewHandler(router, logjam.MiddlewareOptions{})
+
+	Convey("using a custom formatter", t, func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/users/123", nil))
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Custom::Users#get")
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://acme.example.com/", nil))
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Tenants#show")
+	})
+}
+
+func TestSubrouterPrefixes(t *testing.T) {
+	router := mux.NewRouter()
+	somebody := func(w http.ResponseWriter, req *http.Request) {}
+	api := router.PathPrefix("/api/v2").Subrouter()
+	ActionName(api.Path("/users").Methods("GET").HandlerFunc(somebody), "Users")
+	ActionName(api.Path("/groups").Methods("GET").HandlerFunc(somebody), "Api::V2::Groups")
+	ActionName(api.Path("/teams").Methods("GET").HandlerFunc(somebody), "Teams#index")
+	api.Path("/users/{user_id}/friends").Methods("GET").HandlerFunc(somebody)
+	admin := api.PathPrefix("/admin").Subrouter()
+	ActionName(admin.Path("/users").Methods("GET").HandlerFunc(somebody), "Users")
+	ActionName(router.Path("/status").Methods("GET").HandlerFunc(somebody), "Status")
+
+	SetupRoutes(router)
+	SetupRoutes(router)
+
+	agent := logjam.NewAgent(&logjam.Options{Logger: log.New(ioutil.Discard, "", 0)})
+	handler := agent.NewHandler(router, logjam.MiddlewareOptions{})
+	actionName := func(path string) string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Header().Get("X-Logjam-Action")
+	}
+
+	Convey("nesting action names under subrouter path prefixes", t, func() {
+		So(actionName("/api/v2/users"), ShouldEqual, "Api::V2::Users#get")
+		So(actionName("/api/v2/groups"), ShouldEqual, "Api::V2::Groups#get")
+		So(actionName("/api/v2/teams"), ShouldEqual, "Teams#index")
+		So(actionName("/api/v2/users/123/friends"), ShouldEqual, "Api::V2::Users#friends")
+		So(actionName("/api/v2/admin/users"), ShouldEqual, "Api::V2::Admin::Users#get")
+		So(actionName("/status"), ShouldEqual, "Status#get")
+	})
+}
+
+func TestIgnoreAndSample(t *testing.T) {
+	router := mux.NewRouter()
+	somebody := func(w http.ResponseWriter, req *http.Request) {}
+	Ignore(router.Path("/alive").Methods("GET").HandlerFunc(somebody))
+	route := router.Path("/users").Methods("GET").HandlerFunc(somebody)
+	Sample(route, 0.25)
+	ActionName(route, "Users#index")
+	router.Path("/groups").Methods("GET").HandlerFunc(somebody)
+	SetupRoutes(router)
+
+	agent := logjam.NewAgent(&logjam.Options{Logger: log.New(ioutil.Discard, "", 0)})
+	finished := map[string]*logjam.Request{}
+	handler := agent.NewHandler(router, logjam.MiddlewareOptions{
+		SampleRate: 0.5,
+		BeforeFinish: func(r *http.Request, req *logjam.Request, code int) {
+			finished[r.URL.Path] = req
+		},
+	})
+	perform := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	Convey("ignoring routes", t, func() {
+		perform("/alive")
+		So(finished["/alive"].Ignored(), ShouldBeTrue)
+		So(finished["/alive"].GetField("sample_rate"), ShouldEqual, 0.5)
+	})
+
+	Convey("sampling routes", t, func() {
+		So(perform("/users").Header().Get("X-Logjam-Action"), ShouldEqual, "Users#index")
+		So(finished["/users"].Ignored(), ShouldBeFalse)
+		So(finished["/users"].GetField("sample_rate"), ShouldEqual, 0.25)
+	})
+
+	Convey("deriving action names for other routes", t, func() {
+		So(perform("/groups").Header().Get("X-Logjam-Action"), ShouldEqual, "Groups#get")
+		So(finished["/groups"].GetField("sample_rate"), ShouldEqual, 0.5)
+	})
+}
+
+func TestRoutes(t *testing.T) {
+	router := mux.NewRouter()
+	somebody := func(w http.ResponseWriter, req *http.Request) {}
+	ActionName(router.Path("/users/{user_id}").Methods("PUT", "PATCH").HandlerFunc(somebody), "Users#update")
+	ActionName(router.Path("/users/{user_id}").Methods("POST").HandlerFunc(somebody), "Users#methodNotAllowed")
+	router.Path("/groups").HandlerFunc(somebody)
+	router.Path("/unnamed").HandlerFunc(somebody)
+	SetupRoutesWithFormatter(router, func(t RouteTemplates) string {
+		if t.Path == "/unnamed" {
+			return ""
+		}
+		return DefaultActionNameFormatter(t)
+	})
+
+	Convey("listing routes", t, func() {
+		So(Routes(router), ShouldResemble, []Route{
+			{Method: "ALL", Path: "/groups", Action: "Groups#:method"},
+			{Method: "PUT", Path: "/users/{user_id}", Action: "Users#update"},
+			{Method: "PATCH", Path: "/users/{user_id}", Action: "Users#update"},
+			{Method: "POST", Path: "/users/{user_id}", Action: "Users#methodNotAllowed", NotAllowed: true},
+		})
+	})
+
+	Convey("writing routes as JSON", t, func() {
+		var b strings.Builder
+		So(WriteRoutesJSON(&b, router), ShouldBeNil)
+		var routes []map[string]interface{}
+		So(json.Unmarshal([]byte(b.String()), &routes), ShouldBeNil)
+		So(routes, ShouldHaveLength, 4)
+		So(routes[3], ShouldResemble, map[string]interface{}{
+			"method": "POST", "path": "/users/{user_id}", "action": "Users#methodNotAllowed", "not_allowed": true,
+		})
+	})
+}
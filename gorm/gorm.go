@@ -0,0 +1,79 @@
+package gorm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/sanitize"
+	"gorm.io/gorm"
+)
+
+// startKey stores the start time of an operation in the gorm statement.
+const startKey = "logjam:start"
+
+// Plugin is a GORM plugin recording the time spent on database operations as db_time and
+// their number as db_calls on the logjam request of the session context, as set with
+// db.WithContext(ctx). Operations running longer than SlowQueryThreshold are logged as WARN
+// lines, with literals removed from the SQL. Install it using db.Use(&gorm.Plugin{}).
+type Plugin struct {
+	SlowQueryThreshold time.Duration // Operations running longer are logged, 0 disables logging.
+}
+
+// Name implements gorm.Plugin.
+func (p *Plugin) Name() string {
+	return "logjam"
+}
+
+// Initialize implements gorm.Plugin by registering callbacks around all operations.
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	type register func(name string, fn func(*gorm.DB)) error
+	cb := db.Callback()
+	operations := []struct {
+		name          string
+		before, after register
+	}{
+		{"create", cb.Create().Before("*").Register, cb.Create().After("*").Register},
+		{"query", cb.Query().Before("*").Register, cb.Query().After("*").Register},
+		{"update", cb.Update().Before("*").Register, cb.Update().After("*").Register},
+		{"delete", cb.Delete().Before("*").Register, cb.Delete().After("*").Register},
+		{"row", cb.Row().Before("*").Register, cb.Row().After("*").Register},
+		{"raw", cb.Raw().Before("*").Register, cb.Raw().After("*").Register},
+	}
+	for _, op := range operations {
+		if err := op.before("logjam:before_"+op.name, before); err != nil {
+			return err
+		}
+		if err := op.after("logjam:after_"+op.name, p.after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func before(db *gorm.DB) {
+	if db.Statement == nil || logjam.GetRequest(db.Statement.Context) == nil {
+		return
+	}
+	db.InstanceSet(startKey, time.Now())
+}
+
+func (p *Plugin) after(db *gorm.DB) {
+	if db.Statement == nil {
+		return
+	}
+	request := logjam.GetRequest(db.Statement.Context)
+	if request == nil {
+		return
+	}
+	value, ok := db.InstanceGet(startKey)
+	if !ok {
+		return
+	}
+	duration := time.Since(value.(time.Time))
+	request.AddDuration("db_time", duration)
+	request.Count("db_calls")
+	if p.SlowQueryThreshold > 0 && duration > p.SlowQueryThreshold {
+		request.Log(logjam.WARN, fmt.Sprintf("slow query: %s exceeds %s: %s", duration, p.SlowQueryThreshold, sanitize.SQL(db.Statement.SQL.String())))
+	}
+}
@@ -0,0 +1,38 @@
+package gorm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+	"gorm.io/gorm"
+)
+
+func TestPlugin(t *testing.T) {
+	collector := logjamtest.NewCollector("gorm-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("recording operations on the logjam request", t, func() {
+		request := agent.NewRequest("Users#index")
+		db := &gorm.DB{Statement: &gorm.Statement{Context: request.NewContext(context.Background())}}
+		db.Statement.SQL.WriteString("SELECT * FROM users WHERE name = 'Alice'")
+
+		plugin := &Plugin{SlowQueryThreshold: time.Nanosecond}
+		before(db)
+		time.Sleep(time.Millisecond)
+		plugin.after(db)
+		request.Finish(200)
+
+		output := collector.Receive()
+
+		So(output["db_calls"], ShouldEqual, 1)
+		So(output["db_time"], ShouldBeGreaterThanOrEqualTo, 1.0)
+		lines := output["lines"].([]interface{})
+		So(lines, ShouldHaveLength, 1)
+		So(lines[0].([]interface{})[2], ShouldContainSubstring, "SELECT * FROM users WHERE name = ?")
+	})
+}
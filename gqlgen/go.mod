@@ -0,0 +1,23 @@
+module github.com/xing/logjam-agent-go/gqlgen
+
+go 1.22
+
+require (
+	github.com/99designs/gqlgen v0.17.40
+	github.com/smartystreets/goconvey v1.6.4
+	github.com/vektah/gqlparser/v2 v2.5.10
+	github.com/xing/logjam-agent-go v0.1.0
+)
+
+require (
+	github.com/felixge/httpsnoop v1.0.3 // indirect
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/gopherjs/gopherjs v0.0.0-20181017120253-0766667cb4d1 // indirect
+	github.com/jtolds/gls v4.20.0+incompatible // indirect
+	github.com/pebbe/zmq4 v1.2.0 // indirect
+	github.com/smartystreets/assertions v0.0.0-20180927180507-b2de0cb4f26d // indirect
+	github.com/sosodev/duration v1.1.0 // indirect
+)
+
+replace github.com/xing/logjam-agent-go => ../
@@ -0,0 +1,94 @@
+package gqlgen
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/names"
+)
+
+// Extension is a gqlgen handler extension instrumenting GraphQL operations served by a
+// handler wrapped in the logjam middleware. It names the logjam request after the
+// operation, e.g. "Graphql::Query#userProfile", records the time spent in each resolver
+// and the number of its calls like graphql_query_user_profile_time and
+// graphql_query_user_profile_calls, and adds an exception for each GraphQL error in the
+// response. As resolvers nest, run concurrently and include the time of database and
+// service calls made by them, resolver durations overlap with each other and with other
+// time metrics. Logjam scales all durations of a request down if their sum exceeds the
+// total time. Install it using srv.Use(gqlgen.Extension{}).
+type Extension struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationInterceptor
+	graphql.ResponseInterceptor
+	graphql.FieldInterceptor
+} = Extension{}
+
+// ExtensionName implements graphql.HandlerExtension.
+func (Extension) ExtensionName() string {
+	return "Logjam"
+}
+
+// Validate implements graphql.HandlerExtension.
+func (Extension) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+// InterceptOperation implements graphql.OperationInterceptor.
+func (Extension) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	if logjam.GetRequest(ctx) != nil {
+		logjam.SetAction(ctx, actionName(graphql.GetOperationContext(ctx)))
+	}
+	return next(ctx)
+}
+
+// InterceptResponse implements graphql.ResponseInterceptor.
+func (Extension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	response := next(ctx)
+	request := logjam.GetRequest(ctx)
+	if request == nil || response == nil {
+		return response
+	}
+	for _, err := range response.Errors {
+		request.AddException(names.GraphQLException(err.Extensions))
+		request.Log(logjam.ERROR, "GraphQL error: "+err.Message)
+	}
+	return response
+}
+
+// InterceptField implements graphql.FieldInterceptor.
+func (Extension) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	request := logjam.GetRequest(ctx)
+	field := graphql.GetFieldContext(ctx)
+	if request == nil || field == nil || !field.IsResolver {
+		return next(ctx)
+	}
+	name := metricName(field.Object, field.Field.Name)
+	start := time.Now()
+	result, err := next(ctx)
+	request.AddDuration(name+"_time", time.Since(start))
+	request.Count(name + "_calls")
+	return result, err
+}
+
+func actionName(operation *graphql.OperationContext) string {
+	kind, name := "query", operation.OperationName
+	if operation.Operation != nil {
+		kind = string(operation.Operation.Operation)
+		if name == "" {
+			name = operation.Operation.Name
+		}
+	}
+	if name == "" {
+		name = "anonymous"
+	}
+	return "Graphql::" + strings.Title(kind) + "#" + name
+}
+
+func metricName(object, field string) string {
+	return "graphql_" + names.SnakeCase(object) + "_" + names.SnakeCase(field)
+}
@@ -0,0 +1,76 @@
+package gqlgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestNames(t *testing.T) {
+	Convey("naming requests after the operation", t, func() {
+		So(actionName(&graphql.OperationContext{
+			OperationName: "userProfile",
+			Operation:     &ast.OperationDefinition{Operation: ast.Query, Name: "userProfile"},
+		}), ShouldEqual, "Graphql::Query#userProfile")
+		So(actionName(&graphql.OperationContext{
+			Operation: &ast.OperationDefinition{Operation: ast.Mutation, Name: "updateUser"},
+		}), ShouldEqual, "Graphql::Mutation#updateUser")
+		So(actionName(&graphql.OperationContext{
+			Operation: &ast.OperationDefinition{Operation: ast.Query},
+		}), ShouldEqual, "Graphql::Query#anonymous")
+	})
+
+	Convey("naming resolver metrics", t, func() {
+		So(metricName("Query", "userProfile"), ShouldEqual, "graphql_query_user_profile")
+		So(metricName("User", "id"), ShouldEqual, "graphql_user_id")
+	})
+}
+
+func TestExtension(t *testing.T) {
+	collector := logjamtest.NewCollector("gqlgen-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("instrumenting an operation", t, func() {
+		// Start in the past, so that durations aren't scaled down to the total time.
+		request := agent.NewRequestAt("Graphql#post", time.Now().Add(-time.Second))
+		ctx := request.NewContext(context.Background())
+		ctx = graphql.WithOperationContext(ctx, &graphql.OperationContext{
+			OperationName: "userProfile",
+			Operation:     &ast.OperationDefinition{Operation: ast.Query, Name: "userProfile"},
+		})
+
+		extension := Extension{}
+		handler := extension.InterceptOperation(ctx, func(ctx context.Context) graphql.ResponseHandler {
+			return func(ctx context.Context) *graphql.Response {
+				fieldCtx := graphql.WithFieldContext(ctx, &graphql.FieldContext{
+					Object:     "Query",
+					Field:      graphql.CollectedField{Field: &ast.Field{Name: "userProfile"}},
+					IsResolver: true,
+				})
+				extension.InterceptField(fieldCtx, func(ctx context.Context) (interface{}, error) {
+					time.Sleep(10 * time.Millisecond)
+					return "profile", nil
+				})
+				return &graphql.Response{Errors: gqlerror.List{gqlerror.Errorf("user not found")}}
+			}
+		})
+		response := extension.InterceptResponse(ctx, handler)
+		So(response.Errors, ShouldHaveLength, 1)
+		request.Finish(200)
+
+		output := collector.Receive()
+
+		So(output["action"], ShouldEqual, "Graphql::Query#userProfile")
+		So(output["graphql_query_user_profile_calls"], ShouldEqual, 1)
+		So(output["graphql_query_user_profile_time"], ShouldBeBetween, 10.0, 1000.0)
+		So(output["exceptions"], ShouldResemble, []interface{}{"GraphQLError"})
+	})
+}
@@ -0,0 +1,110 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/names"
+)
+
+// operationPattern extracts the operation name from GraphQL documents.
+var operationPattern = regexp.MustCompile(`^\s*(?:query|mutation|subscription)\s+(\w+)`)
+
+// Transport is an http.RoundTripper for calling GraphQL services. It adds the logjam call
+// headers of the logjam request found in the request context and records the time spent on
+// calls as graphql_time and their number as graphql_calls on that request. Calls of named
+// operations are also counted per operation, e.g. as graphql_user_profile_calls for the
+// operation "userProfile". Responses with an errors array
+// add an exception per error, "GraphQL::<code>" for errors with an extensions code or
+// "GraphQLError" otherwise. Create it using NewTransport.
+type Transport struct {
+	base http.RoundTripper
+}
+
+// NewTransport creates a Transport sending requests using the given base transport, or
+// http.DefaultTransport if nil.
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	request := logjam.GetRequest(r.Context())
+	if request == nil {
+		return t.base.RoundTrip(r)
+	}
+	// RoundTrippers must not modify the request.
+	outgoing := r.Clone(r.Context())
+	logjam.SetCallHeaders(r.Context(), outgoing)
+	operation := ""
+	if r.Body != nil && r.GetBody != nil {
+		if body, err := r.GetBody(); err == nil {
+			operation = operationName(body)
+			body.Close()
+		}
+	}
+	start := time.Now()
+	res, err := t.base.RoundTrip(outgoing)
+	if err == nil && res.StatusCode == http.StatusOK {
+		err = recordErrors(request, res)
+	}
+	request.AddDuration("graphql_time", time.Since(start))
+	request.Count("graphql_calls")
+	if operation != "" {
+		request.Count("graphql_" + names.SnakeCase(operation) + "_calls")
+	}
+	return res, err
+}
+
+// operationName returns the operation name of a GraphQL request body, or "" if the body
+// isn't a GraphQL request or the operation is anonymous.
+func operationName(body io.Reader) string {
+	var payload struct {
+		Query         string `json:"query"`
+		OperationName string `json:"operationName"`
+	}
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		return ""
+	}
+	if payload.OperationName != "" {
+		return payload.OperationName
+	}
+	if match := operationPattern.FindStringSubmatch(payload.Query); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// recordErrors reads the response body, adding an exception for each GraphQL error, and
+// replaces the body so that the caller can read it.
+func recordErrors(request *logjam.Request, res *http.Response) error {
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	var payload struct {
+		Errors []struct {
+			Message    string                 `json:"message"`
+			Extensions map[string]interface{} `json:"extensions"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &payload) != nil {
+		return nil
+	}
+	for _, e := range payload.Errors {
+		request.AddException(names.GraphQLException(e.Extensions))
+		request.Log(logjam.ERROR, "GraphQL call error: "+e.Message)
+	}
+	return nil
+}
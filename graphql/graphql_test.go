@@ -0,0 +1,66 @@
+package graphql
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestOperationName(t *testing.T) {
+	Convey("extracting operation names", t, func() {
+		So(operationName(strings.NewReader(`{"query":"query userProfile { me { id } }"}`)), ShouldEqual, "userProfile")
+		So(operationName(strings.NewReader(`{"query":"query A { a } query B { b }","operationName":"B"}`)), ShouldEqual, "B")
+		So(operationName(strings.NewReader(`{"query":"{ me { id } }"}`)), ShouldEqual, "")
+		So(operationName(strings.NewReader(`not json`)), ShouldEqual, "")
+	})
+}
+
+func TestTransport(t *testing.T) {
+	collector := logjamtest.NewCollector("graphql-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	var action string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action = r.Header.Get("X-Logjam-Action")
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":null,"errors":[{"message":"not found","extensions":{"code":"NOT_FOUND"}},{"message":"oops"}]}`))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: NewTransport(nil)}
+
+	Convey("calling GraphQL services", t, func() {
+		// Start in the past, so that durations aren't scaled down to the total time.
+		request := agent.NewRequestAt("Users#show", time.Now().Add(-time.Second))
+		request.AddDuration("db_time", 5*time.Millisecond)
+		ctx := request.NewContext(context.Background())
+
+		r, _ := http.NewRequest("POST", server.URL, strings.NewReader(`{"query":"query userProfile { me { id } }"}`))
+		res, err := client.Do(r.WithContext(ctx))
+		So(err, ShouldBeNil)
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		So(string(body), ShouldStartWith, `{"data":null`)
+		So(action, ShouldEqual, "Users#show")
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output["graphql_time"], ShouldBeBetween, 20.0, 1000.0)
+		So(output["graphql_calls"], ShouldEqual, 1)
+		So(output["graphql_user_profile_calls"], ShouldEqual, 1)
+		So(output, ShouldNotContainKey, "graphql_user_profile_time")
+		So(output["db_time"], ShouldEqual, 5.0)
+		So(output["exceptions"], ShouldHaveLength, 2)
+		So(output["exceptions"], ShouldContain, "GraphQL::NOT_FOUND")
+		So(output["exceptions"], ShouldContain, "GraphQLError")
+	})
+}
@@ -0,0 +1,143 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xing/logjam-agent-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryClientInterceptor adds the logjam call headers of the logjam request found in the
+// context to the outgoing metadata, like logjam.SetCallHeaders does for HTTP requests, and
+// records the time spent on the call as grpc_time and the number of calls as grpc_calls
+// on the logjam request. Install it using grpc.WithUnaryInterceptor.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		request := logjam.GetRequest(ctx)
+		if request == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		start := time.Now()
+		err := invoker(outgoingContext(ctx), method, req, reply, cc, opts...)
+		request.AddDuration("grpc_time", time.Since(start))
+		request.Count("grpc_calls")
+		return err
+	}
+}
+
+// StreamClientInterceptor is the streaming counterpart of UnaryClientInterceptor. The
+// time recorded for a stream lasts until the stream has been received completely, has
+// failed or its context is done. For client streaming calls, which receive a single
+// response, it lasts until the response has been received. Install it using
+// grpc.WithStreamInterceptor.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		request := logjam.GetRequest(ctx)
+		if request == nil {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		request.Count("grpc_calls")
+		start := time.Now()
+		stream, err := streamer(outgoingContext(ctx), desc, cc, method, opts...)
+		if err != nil {
+			request.AddDuration("grpc_time", time.Since(start))
+			return nil, err
+		}
+		s := &clientStream{
+			ClientStream:  stream,
+			request:       request,
+			start:         start,
+			serverStreams: desc.ServerStreams,
+			done:          make(chan struct{}),
+		}
+		if ctx.Done() != nil {
+			go func() {
+				select {
+				case <-ctx.Done():
+					s.finish()
+				case <-s.done:
+				}
+			}()
+		}
+		return s, nil
+	}
+}
+
+// outgoingContext returns a context with the logjam call headers appended to the
+// outgoing metadata. Metadata keys are lowercase.
+func outgoingContext(ctx context.Context) context.Context {
+	headers := logjam.CallHeaders(ctx)
+	kv := make([]string, 0, 2*len(headers))
+	for name, values := range headers {
+		for _, value := range values {
+			kv = append(kv, strings.ToLower(name), value)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// clientStream records the stream duration on the logjam request once receiving ends.
+type clientStream struct {
+	grpc.ClientStream
+	request       *logjam.Request // the logjam request of the caller
+	start         time.Time       // when the stream was opened
+	serverStreams bool            // whether the server sends more than one message
+	once          sync.Once       // makes sure the duration is only recorded once
+	done          chan struct{}   // closed once the duration has been recorded
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.finish()
+	}
+	return err
+}
+
+// finish records the stream duration, unless it has been recorded already.
+func (s *clientStream) finish() {
+	s.once.Do(func() {
+		s.request.AddDuration("grpc_time", time.Since(s.start))
+		close(s.done)
+	})
+}
+
+// Conn is implemented by *grpc.ClientConn.
+type Conn interface {
+	GetState() connectivity.State
+}
+
+var _ Conn = (*grpc.ClientConn)(nil)
+
+// WatchConnectionState samples the state of a long lived client connection in the given
+// interval and attaches the samples as counters like grpc_<name>_ready and
+// grpc_<name>_transient_failure to the next request the agent sends to logjam. This helps
+// correlating connectivity problems with failing requests. Call the returned function to
+// stop watching.
+func WatchConnectionState(agent *logjam.Agent, conn Conn, name string, interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				state := strings.ToLower(conn.GetState().String())
+				agent.AttachCount("grpc_"+name+"_"+state, 1)
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
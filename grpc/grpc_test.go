@@ -0,0 +1,169 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+)
+
+type fakeStream struct {
+	grpc.ClientStream
+	messages int
+}
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	if s.messages == 0 {
+		return io.EOF
+	}
+	s.messages--
+	return nil
+}
+
+func TestClientInterceptors(t *testing.T) {
+	collector := logjamtest.NewCollector("grpc-test", logjam.Options{
+		AppName: "appName",
+		EnvName: "envName",
+	})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("unary calls", t, func() {
+		request := agent.NewRequest("Users#show")
+		request.SetTraceID("4bf92f3577b34da6a3ce929d0e0e4736")
+		ctx := request.NewContext(context.Background())
+		var md metadata.MD
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		}
+		interceptor := UnaryClientInterceptor()
+		So(interceptor(ctx, "/users.Users/Get", nil, nil, nil, invoker), ShouldBeNil)
+		So(interceptor(ctx, "/users.Users/Get", nil, nil, nil, invoker), ShouldBeNil)
+
+		So(md.Get("x-logjam-caller-id"), ShouldHaveLength, 1)
+		So(md.Get("x-logjam-caller-id")[0], ShouldStartWith, "appName-envName-")
+		So(md.Get("x-logjam-action"), ShouldResemble, []string{"Users#show"})
+		So(md.Get("x-logjam-trace-id"), ShouldResemble, []string{"4bf92f3577b34da6a3ce929d0e0e4736"})
+
+		request.Finish(200)
+		output := collector.Receive()
+		So(output["grpc_calls"], ShouldEqual, 2)
+		So(output, ShouldContainKey, "grpc_time")
+	})
+
+	Convey("streaming calls", t, func() {
+		request := agent.NewRequest("Users#index")
+		ctx := request.NewContext(context.Background())
+		streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &fakeStream{messages: 2}, nil
+		}
+		stream, err := StreamClientInterceptor()(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/users.Users/List", streamer)
+		So(err, ShouldBeNil)
+		for err == nil {
+			err = stream.RecvMsg(nil)
+		}
+		So(err, ShouldEqual, io.EOF)
+
+		request.Finish(200)
+		output := collector.Receive()
+		So(output["grpc_calls"], ShouldEqual, 1)
+		So(output, ShouldContainKey, "grpc_time")
+	})
+
+	Convey("client streaming calls", t, func() {
+		request := agent.NewRequest("Users#import")
+		ctx := request.NewContext(context.Background())
+		streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &fakeStream{messages: 1}, nil
+		}
+		stream, err := StreamClientInterceptor()(ctx, &grpc.StreamDesc{ClientStreams: true}, nil, "/users.Users/Import", streamer)
+		So(err, ShouldBeNil)
+		So(stream.RecvMsg(nil), ShouldBeNil)
+		<-stream.(*clientStream).done
+
+		request.Finish(200)
+		output := collector.Receive()
+		So(output["grpc_calls"], ShouldEqual, 1)
+		So(output, ShouldContainKey, "grpc_time")
+	})
+
+	Convey("streams abandoned before the end", t, func() {
+		request := agent.NewRequest("Users#index")
+		ctx, cancel := context.WithCancel(request.NewContext(context.Background()))
+		streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &fakeStream{messages: 2}, nil
+		}
+		stream, err := StreamClientInterceptor()(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/users.Users/List", streamer)
+		So(err, ShouldBeNil)
+		So(stream.RecvMsg(nil), ShouldBeNil)
+		cancel()
+		<-stream.(*clientStream).done
+
+		request.Finish(200)
+		output := collector.Receive()
+		So(output["grpc_calls"], ShouldEqual, 1)
+		So(output, ShouldContainKey, "grpc_time")
+	})
+
+	Convey("calls without logjam request", t, func() {
+		invoked := false
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			_, invoked = metadata.FromOutgoingContext(ctx)
+			return nil
+		}
+		So(UnaryClientInterceptor()(context.Background(), "/users.Users/Get", nil, nil, nil, invoker), ShouldBeNil)
+		So(invoked, ShouldBeFalse)
+	})
+}
+
+// flappingConn alternates between ready and transient failure.
+type flappingConn struct {
+	mutex sync.Mutex
+	calls int
+}
+
+func (c *flappingConn) GetState() connectivity.State {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls++
+	if c.calls%2 == 1 {
+		return connectivity.Ready
+	}
+	return connectivity.TransientFailure
+}
+
+func TestWatchConnectionState(t *testing.T) {
+	collector := logjamtest.NewCollector("grpc-state-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("attaching connection states to the next request", t, func() {
+		conn := &flappingConn{}
+		stop := WatchConnectionState(agent, conn, "users", time.Millisecond)
+		for {
+			conn.mutex.Lock()
+			calls := conn.calls
+			conn.mutex.Unlock()
+			if calls >= 2 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		stop()
+		stop()
+		agent.NewRequest("Users#show").Finish(200)
+
+		output := collector.Receive()
+		So(output["grpc_users_ready"], ShouldBeGreaterThanOrEqualTo, 1)
+		So(output["grpc_users_transient_failure"], ShouldBeGreaterThanOrEqualTo, 1)
+	})
+}
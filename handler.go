@@ -6,8 +6,9 @@ import "net/http"
 // use gorilla/mux, you can install it on your router using r.NotFoundHandler =
 // http.HandlerFunc(logjam.NotFoundHandler).
 func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
-	request := GetRequest(r.Context())
-	request.ChangeAction(w, "System#notFound")
+	if request := GetRequest(r.Context()); request != nil {
+		request.ChangeAction(w, "System#notFound")
+	}
 
 	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 }
@@ -16,8 +17,9 @@ func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 // requests. If you use gorilla/mux, you can install it on your router using
 // r.MethodNotAllowedHandler = http.HandlerFunc(logjam.MethodNotAllowedHandler).
 func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
-	request := GetRequest(r.Context())
-	request.ChangeAction(w, "System#methodNotAllowed")
+	if request := GetRequest(r.Context()); request != nil {
+		request.ChangeAction(w, "System#methodNotAllowed")
+	}
 
 	w.WriteHeader(http.StatusMethodNotAllowed)
 }
@@ -0,0 +1,37 @@
+package httprouter
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/xing/logjam-agent-go"
+)
+
+// Handle registers a handle for the given method and path on the router, using the given
+// logjam action name for the route. An empty action name makes the handle use an action
+// name derived from the path, where named and catch-all parameters become "Id", e.g.
+// "Users::Id::Friends#get" for "/users/:id/friends". Action names without a "#" get the
+// HTTP request method in lowercase appended.
+func Handle(router *httprouter.Router, method, path, actionName string, handle httprouter.Handle) {
+	router.Handle(method, path, ActionName(method, path, actionName, handle))
+}
+
+// ActionName wraps a handle so that requests served by it get the given logjam action
+// name. Use it for routes which are registered with the router directly. See Handle for
+// how the action name is determined.
+func ActionName(method, path, actionName string, handle httprouter.Handle) httprouter.Handle {
+	switch {
+	case actionName == "":
+		actionName = logjam.RouteTemplateActionName(method, path)
+	case !strings.Contains(actionName, "#"):
+		actionName += "#" + strings.ToLower(method)
+	}
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if request := logjam.GetRequest(r.Context()); request != nil {
+			request.SetRouteTemplate(path)
+			request.ChangeAction(w, actionName)
+		}
+		handle(w, r, params)
+	}
+}
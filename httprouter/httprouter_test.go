@@ -0,0 +1,45 @@
+package httprouter
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+)
+
+func TestActionNames(t *testing.T) {
+	router := httprouter.New()
+	somebody := func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		w.Write([]byte(params.ByName("user_id")))
+	}
+	Handle(router, "GET", "/users/:user_id", "Users#show", somebody)
+	Handle(router, "POST", "/users/:user_id/friends", "Users::Friends", somebody)
+	Handle(router, "GET", "/users/:user_id/friends", "", somebody)
+	router.GET("/groups/:group_id", ActionName("GET", "/groups/:group_id", "", somebody))
+
+	agent := logjam.NewAgent(&logjam.Options{Logger: log.New(ioutil.Discard, "", 0)})
+	handler := agent.NewHandler(router, logjam.MiddlewareOptions{})
+
+	perform := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	Convey("registering explicit action names", t, func() {
+		w := perform("GET", "/users/123")
+		So(w.Body.String(), ShouldEqual, "123")
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Users#show")
+		So(perform("POST", "/users/123/friends").Header().Get("X-Logjam-Action"), ShouldEqual, "Users::Friends#post")
+	})
+
+	Convey("deriving action names from route paths", t, func() {
+		So(perform("GET", "/users/123/friends").Header().Get("X-Logjam-Action"), ShouldEqual, "Users::Id::Friends#get")
+		So(perform("GET", "/groups/abc").Header().Get("X-Logjam-Action"), ShouldEqual, "Groups::Id#get")
+	})
+}
@@ -0,0 +1,33 @@
+package instrument
+
+import (
+	"context"
+	"time"
+
+	"github.com/xing/logjam-agent-go"
+)
+
+// Wrap calls f, recording the time spent as <key>_time and the call as <key>_calls on the
+// logjam request found in the context, e.g. pdf_time and pdf_calls for the key "pdf".
+// Returns the error returned by f.
+func Wrap(ctx context.Context, key string, f func() error) error {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return f()
+	}
+	start := time.Now()
+	err := f()
+	request.AddDuration(key+"_time", time.Since(start))
+	request.Count(key + "_calls")
+	return err
+}
+
+// WrapT is like Wrap for functions returning a value besides the error.
+func WrapT[T any](ctx context.Context, key string, f func() (T, error)) (T, error) {
+	var value T
+	err := Wrap(ctx, key, func() (err error) {
+		value, err = f()
+		return err
+	})
+	return value, err
+}
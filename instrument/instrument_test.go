@@ -0,0 +1,37 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestWrap(t *testing.T) {
+	collector := logjamtest.NewCollector("instrument-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("recording wrapped code blocks", t, func() {
+		request := agent.NewRequest("Invoices#show")
+		ctx := request.NewContext(context.Background())
+
+		failure := errors.New("rendering failed")
+		So(Wrap(ctx, "pdf", func() error { return nil }), ShouldBeNil)
+		So(Wrap(ctx, "pdf", func() error { return failure }), ShouldEqual, failure)
+		value, err := WrapT(ctx, "pricing", func() (int, error) { return 42, nil })
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, 42)
+		So(Wrap(context.Background(), "pdf", func() error { return nil }), ShouldBeNil)
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output, ShouldContainKey, "pdf_time")
+		So(output["pdf_calls"], ShouldEqual, 2)
+		So(output, ShouldContainKey, "pricing_time")
+		So(output["pricing_calls"], ShouldEqual, 1)
+	})
+}
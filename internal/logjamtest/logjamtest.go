@@ -0,0 +1,61 @@
+// Package logjamtest provides a fixture for tests of the integrations, receiving the
+// messages an agent sends to logjam.
+package logjamtest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+
+	"github.com/golang/snappy"
+	"github.com/pebbe/zmq4"
+	"github.com/xing/logjam-agent-go"
+)
+
+// Collector receives the messages sent by its agent on an inproc ZeroMQ socket.
+type Collector struct {
+	Agent  *logjam.Agent
+	socket *zmq4.Socket
+}
+
+// NewCollector binds a socket to the inproc endpoint with the given name and creates an
+// agent sending to it. The Endpoints option is overridden, the Logger option defaults to
+// discarding all output.
+func NewCollector(name string, options logjam.Options) *Collector {
+	socket, err := zmq4.NewSocket(zmq4.ROUTER)
+	if err != nil {
+		panic("cannot create socket for testing")
+	}
+	endpoint := "inproc://" + name
+	if err := socket.Bind(endpoint); err != nil {
+		panic("cannot bind socket for testing")
+	}
+	options.Endpoints = endpoint
+	if options.Logger == nil {
+		options.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	return &Collector{Agent: logjam.NewAgent(&options), socket: socket}
+}
+
+// Receive waits for the next message sent by the agent and returns its decoded payload.
+func (c *Collector) Receive() map[string]interface{} {
+	msg, err := c.socket.RecvMessage(0)
+	if err != nil {
+		panic("cannot receive message for testing: " + err.Error())
+	}
+	payload, err := snappy.Decode(nil, []byte(msg[3]))
+	if err != nil {
+		panic("cannot decode message for testing: " + err.Error())
+	}
+	output := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &output); err != nil {
+		panic("cannot parse message for testing: " + err.Error())
+	}
+	return output
+}
+
+// Close shuts down the agent and closes the socket.
+func (c *Collector) Close() {
+	c.Agent.Shutdown()
+	c.socket.Close()
+}
@@ -0,0 +1,36 @@
+// Package names derives metric, action and exception names shared by several
+// integrations.
+package names
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SnakeCase converts camel case identifiers like "userProfile" or "GetUser" to snake case,
+// i.e. "user_profile" and "get_user".
+func SnakeCase(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// GraphQLException returns the exception tag for a GraphQL error with the given
+// extensions. It uses the error code from the extensions, if there is one.
+func GraphQLException(extensions map[string]interface{}) string {
+	if code, ok := extensions["code"]; ok && code != nil {
+		if s := fmt.Sprint(code); s != "" {
+			return "GraphQL::" + s
+		}
+	}
+	return "GraphQLError"
+}
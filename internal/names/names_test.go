@@ -0,0 +1,24 @@
+package names
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSnakeCase(t *testing.T) {
+	Convey("converting camel case to snake case", t, func() {
+		So(SnakeCase("userProfile"), ShouldEqual, "user_profile")
+		So(SnakeCase("GetUser"), ShouldEqual, "get_user")
+		So(SnakeCase("id"), ShouldEqual, "id")
+	})
+}
+
+func TestGraphQLException(t *testing.T) {
+	Convey("naming GraphQL exceptions", t, func() {
+		So(GraphQLException(nil), ShouldEqual, "GraphQLError")
+		So(GraphQLException(map[string]interface{}{"code": ""}), ShouldEqual, "GraphQLError")
+		So(GraphQLException(map[string]interface{}{"code": "NOT_FOUND"}), ShouldEqual, "GraphQL::NOT_FOUND")
+		So(GraphQLException(map[string]interface{}{"code": 404}), ShouldEqual, "GraphQL::404")
+	})
+}
@@ -0,0 +1,16 @@
+// Package sanitize removes user data from strings sent to logjam by several integrations.
+package sanitize
+
+import "regexp"
+
+var (
+	stringLiteral  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numericLiteral = regexp.MustCompile(`([^\w$.])\d+(?:\.\d+)?\b`)
+)
+
+// SQL replaces string and numeric literals in SQL with placeholders, so that log lines
+// don't contain user data. Values passed as query parameters never appear in the SQL.
+func SQL(sql string) string {
+	sql = stringLiteral.ReplaceAllString(sql, "?")
+	return numericLiteral.ReplaceAllString(sql, "${1}?")
+}
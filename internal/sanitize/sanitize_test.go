@@ -0,0 +1,14 @@
+package sanitize
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSQL(t *testing.T) {
+	Convey("removing literals from SQL", t, func() {
+		So(SQL("SELECT * FROM users WHERE name = 'O''Brien' AND age > 42"), ShouldEqual, "SELECT * FROM users WHERE name = ? AND age > ?")
+		So(SQL("SELECT * FROM table1 WHERE id = $1 AND score = 1.5"), ShouldEqual, "SELECT * FROM table1 WHERE id = $1 AND score = ?")
+	})
+}
@@ -0,0 +1,86 @@
+package iris
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+	"github.com/xing/logjam-agent-go"
+)
+
+type contextKey int
+
+const (
+	irisContextKey contextKey = iota // stores the iris context in the http request context
+	writerKey                        // stores the response writer in the http request context
+)
+
+// New returns an iris middleware sending iris requests to logjam. Install it using
+// app.Use(iris.New(agent, options)). Route names which look like logjam action names (i.e.
+// contain a "#") are used as action names, e.g. set with
+// app.Get("/users/{id}", ...).Name = "Users#show". All other routes get an action name
+// derived from the route path. The logjam request is stored in the context of the iris
+// request, so handlers can use the logjam logger with ctx.Request().Context().
+func New(agent *logjam.Agent, options logjam.MiddlewareOptions) iris.Handler {
+	h := agent.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context().Value(irisContextKey).(iris.Context)
+		ctx.ResetRequest(r)
+		if request := logjam.GetRequest(r.Context()); request != nil {
+			if route := ctx.GetCurrentRoute(); route != nil {
+				request.SetRouteTemplate(route.Path())
+				request.ChangeAction(w, actionName(route.Name(), r.Method, route.Path()))
+			}
+		}
+		ctx.Next()
+		// Report the response written by the iris handlers to the middleware.
+		rw := r.Context().Value(writerKey).(*responseWriter)
+		rw.mirror = true
+		w.WriteHeader(ctx.GetStatusCode())
+		if request := logjam.GetRequest(r.Context()); request != nil {
+			if n := ctx.ResponseWriter().Written(); n > 0 {
+				request.AddBytes(logjam.ResponseBytes, int64(n))
+			}
+		}
+	}), options)
+	return func(ctx iris.Context) {
+		rw := &responseWriter{ResponseWriter: ctx.ResponseWriter()}
+		r := ctx.Request()
+		c := context.WithValue(r.Context(), irisContextKey, ctx)
+		h.ServeHTTP(rw, r.WithContext(context.WithValue(c, writerKey, rw)))
+	}
+}
+
+// GetRequest retrieves the logjam request from an iris context. Returns nil if the
+// request isn't handled by the logjam middleware.
+func GetRequest(ctx iris.Context) *logjam.Request {
+	return logjam.GetRequest(ctx.Request().Context())
+}
+
+func actionName(routeName, method, path string) string {
+	if strings.Contains(routeName, "#") {
+		return routeName
+	}
+	return logjam.RouteTemplateActionName(method, path)
+}
+
+// responseWriter passes headers and responses written by the logjam middleware, like panic
+// responses, on to the iris response writer. Once mirroring the status code written by the
+// iris handlers, headers and writes are only recorded by the middleware.
+type responseWriter struct {
+	http.ResponseWriter      // the iris response writer
+	mirror              bool // whether the iris handlers have written the response
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if !w.mirror {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.mirror {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
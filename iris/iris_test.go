@@ -0,0 +1,78 @@
+package iris
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kataras/iris/v12"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestNew(t *testing.T) {
+	agent := logjam.NewAgent(&logjam.Options{Logger: log.New(ioutil.Discard, "", 0)})
+	codes := map[string]int{}
+	options := logjam.MiddlewareOptions{
+		BeforeFinish: func(r *http.Request, req *logjam.Request, code int) {
+			codes[r.URL.Path] = code
+			req.Ignore()
+		},
+	}
+
+	app := iris.New()
+	app.Use(New(agent, options))
+	app.Get("/users/{id:uint64}", func(ctx iris.Context) {
+		if GetRequest(ctx) == nil {
+			ctx.StatusCode(http.StatusInternalServerError)
+			return
+		}
+		ctx.WriteString("ok")
+	}).Name = "Users#show"
+	app.Get("/users/{id:uint64}/friends", func(ctx iris.Context) {
+		ctx.StatusCode(http.StatusForbidden)
+	})
+	app.Build()
+
+	perform := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	Convey("using route names as action names", t, func() {
+		w := perform("/users/123")
+		So(w.Code, ShouldEqual, http.StatusOK)
+		So(w.Body.String(), ShouldEqual, "ok")
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Users#show")
+		So(codes["/users/123"], ShouldEqual, http.StatusOK)
+	})
+
+	Convey("deriving action names from route paths", t, func() {
+		w := perform("/users/123/friends")
+		So(w.Code, ShouldEqual, http.StatusForbidden)
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Users::Id::Friends#get")
+		So(codes["/users/123/friends"], ShouldEqual, http.StatusForbidden)
+	})
+}
+
+func TestResponseBytes(t *testing.T) {
+	collector := logjamtest.NewCollector("iris-test", logjam.Options{})
+	defer collector.Close()
+
+	app := iris.New()
+	app.Use(New(collector.Agent, logjam.MiddlewareOptions{}))
+	app.Get("/users", func(ctx iris.Context) {
+		ctx.WriteString("alice, bob")
+	})
+	app.Build()
+
+	Convey("recording the size of responses written by iris", t, func() {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+		output := collector.Receive()
+		So(output["response_bytes"], ShouldEqual, len("alice, bob"))
+	})
+}
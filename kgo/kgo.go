@@ -0,0 +1,38 @@
+package kgo
+
+import (
+	"context"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/xing/logjam-agent-go"
+)
+
+// AddHeaders adds the logjam call headers of the logjam request found in the context to
+// the record headers, like logjam.SetCallHeaders does for HTTP requests, so that consumers
+// can link their logjam requests to the producing request.
+func AddHeaders(ctx context.Context, r *kgo.Record) {
+	for name, values := range logjam.CallHeaders(ctx) {
+		for _, value := range values {
+			r.Headers = append(r.Headers, kgo.RecordHeader{Key: name, Value: []byte(value)})
+		}
+	}
+}
+
+// ProduceSync adds the logjam call headers to the records and produces them using the
+// given client, recording the time spent as kafka_publish_time and the number of records
+// produced as kafka_publish_calls on the logjam request found in the context.
+func ProduceSync(ctx context.Context, client *kgo.Client, rs ...*kgo.Record) kgo.ProduceResults {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return client.ProduceSync(ctx, rs...)
+	}
+	for _, r := range rs {
+		AddHeaders(ctx, r)
+	}
+	start := time.Now()
+	results := client.ProduceSync(ctx, rs...)
+	request.AddDuration("kafka_publish_time", time.Since(start))
+	request.AddCount("kafka_publish_calls", int64(len(rs)))
+	return results
+}
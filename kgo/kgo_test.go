@@ -0,0 +1,38 @@
+package kgo
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/xing/logjam-agent-go"
+)
+
+func TestAddHeaders(t *testing.T) {
+	Convey("adding call headers to records", t, func() {
+		agent := logjam.NewAgent(&logjam.Options{
+			AppName: "appName",
+			EnvName: "envName",
+			Logger:  log.New(ioutil.Discard, "", 0),
+		})
+		defer agent.Shutdown()
+		request := agent.NewRequest("Users#create")
+		ctx := request.NewContext(context.Background())
+
+		r := &kgo.Record{Topic: "users"}
+		AddHeaders(ctx, r)
+		headers := map[string]string{}
+		for _, header := range r.Headers {
+			headers[header.Key] = string(header.Value)
+		}
+		So(headers["X-Logjam-Action"], ShouldEqual, "Users#create")
+		So(headers["X-Logjam-Caller-Id"], ShouldStartWith, "appName-envName-")
+
+		r = &kgo.Record{Topic: "users"}
+		AddHeaders(context.Background(), r)
+		So(r.Headers, ShouldBeEmpty)
+	})
+}
@@ -2,33 +2,173 @@ package logjam
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
+	"path"
 	"regexp"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
 )
 
 // MiddlewareOptions defines options for the logjam middleware.
 type MiddlewareOptions struct {
-	BubblePanics bool // Whether the logjam middleware should let panics bubble up the handler chain.
+	BubblePanics        bool                     // Whether the logjam middleware should let panics bubble up the handler chain.
+	Headers             HeaderPolicy             // Which request headers are sent to logjam.
+	Cookies             bool                     // Whether request cookies are sent to logjam. The Cookie header is omitted then.
+	RedactCookies       []string                 // Cookies in this list are sent with their value replaced by [FILTERED].
+	FormBodyLimit       int64                    // Max size of url encoded form bodies buffered to capture body parameters. Zero disables buffering.
+	JSONBodyLimit       int64                    // Max size of JSON bodies buffered to capture body parameters. Zero disables buffering.
+	PanicStatusCode     int                      // Response code for panicking handlers which haven't written a response yet. Defaults to 500.
+	PanicResponse       PanicResponder           // Writes the response for panicking handlers which haven't written a response yet.
+	Ignore              func(*http.Request) bool // Requests for which this returns true are not sent to logjam.
+	IgnorePaths         []string                 // Path prefixes or glob patterns (see path.Match) of requests not sent to logjam.
+	SampleRate          float64                  // Fraction of successful requests sent to logjam. Zero means all requests.
+	ActionSampleRates   map[string]float64       // Per action overrides of SampleRate.
+	B3                  B3Format                 // Zipkin B3 header formats to extract and propagate trace ids. Zero disables B3.
+	BeforeFinish        BeforeFinishHook         // Called with the response code before the request is sent to logjam.
+	PanicHandler        PanicHandler             // Reports panics of the handler, replacing the default FATAL log line with stack trace.
+	StreamingThreshold  time.Duration            // Requests running longer are reported as streamed, excluding streaming time from total_time.
+	StatusSeverity      func(code int) LogLevel  // Minimum request severity for a response code, see DefaultStatusSeverity.
+	TagServerErrors     bool                     // Whether 5xx responses without exceptions get an exception tag like internal_server_error.
+	IgnorePreflights    bool                     // Whether CORS preflight requests are not sent to logjam.
+	PreflightSampleRate float64                  // Fraction of CORS preflight requests sent to logjam, if lower than SampleRate. Zero means SampleRate.
+	IgnoreHeadRequests  bool                     // Whether HEAD requests are not sent to logjam. They are counted in Agent.FilteredRequests.
+	BotUserAgents       []string                 // Regular expressions matching User-Agents of requests not sent to logjam, e.g. DefaultBotUserAgents.
+	Logger              *Logger                  // Stored in the request context for handlers to use, see LoggerFromContext.
+	ClassifyUserAgents  bool                     // Whether to send ua_class (browser, bot, api-client or other) and ua_name fields.
+	ObfuscateIPs        *bool                    // Overrides the agent option of the same name for this handler chain, if set.
+	StreamSelector      StreamSelector           // Selects the logjam stream per request, e.g. based on the Host header.
+	StackDumpThreshold  time.Duration            // Requests running longer get an ERROR line with the stack of the serving goroutine, dumped at most once a minute.
+	PathPrefix          string                   // Prepended to request paths for action name extraction, e.g. for handlers behind http.StripPrefix.
+}
+
+// PanicResponder writes the response for a request whose handler panicked before writing
+// a response, e.g. a JSON error body. It must write a header with the given status code.
+type PanicResponder func(w http.ResponseWriter, r *http.Request, code int, recovered interface{})
+
+// StatusClientClosedRequest is the (nginx specific) response code reported to logjam for
+// requests aborted by the handler panicking with http.ErrAbortHandler.
+const StatusClientClosedRequest = 499
+
+// Exception tags added to requests whose context ended before the handler returned.
+const (
+	timeoutException    = "timeout"
+	clientGoneException = "client_gone"
+)
+
+// clientDisconnectException is the exception tag added to requests aborted by the handler
+// panicking with http.ErrAbortHandler. It doesn't raise the severity of the request.
+const clientDisconnectException = "client_disconnect"
+
+// PanicHandler reports a panic of the next handler in the chain to logjam. It is called
+// from within the deferred recovery function of the middleware, so debug.Stack() still
+// returns the stack of the panicking goroutine. A panic handler can, for example, derive
+// an exception tag from the type of the recovered value, omit the stack trace or skip
+// logging altogether when an outer recovery layer logs the panic anyway. Panics reported
+// by a panic handler are not printed to the agent's logger.
+type PanicHandler func(recovered interface{}, req *Request)
+
+// DefaultStatusSeverity maps 4xx response codes to WARN and 5xx response codes to ERROR.
+// Use it as the StatusSeverity middleware option to have failing requests show up with
+// the correct severity in logjam without logging in every handler.
+func DefaultStatusSeverity(code int) LogLevel {
+	switch {
+	case code >= 500:
+		return ERROR
+	case code >= 400:
+		return WARN
+	}
+	return DEBUG
+}
+
+// StreamSelector returns application and environment name of the logjam stream a request
+// is sent to. Empty names default to the names configured on the agent.
+type StreamSelector func(r *http.Request) (appName, envName string)
+
+// BeforeFinishHook is called by the middleware after the handler has returned (or
+// panicked), right before the logjam request is finished. It can be used to attach
+// information which is only known late, like tenant, user id or cache status, change the
+// action name or ignore the request.
+type BeforeFinishHook func(r *http.Request, req *Request, code int)
+
+// HeaderPolicy determines which request headers are sent to logjam. Header names are
+// matched case insensitively. Headers carrying sensitive information, like Authorization,
+// are never sent unless they are explicitly listed in Redact.
+type HeaderPolicy struct {
+	Allow  []string // If not empty, only headers in this list are sent.
+	Deny   []string // Headers in this list are never sent.
+	Redact []string // Headers in this list are sent with their value replaced by [FILTERED].
+
+	// RedactSensitive makes the middleware send authorization headers with their
+	// credentials replaced by [FILTERED], preserving the authentication scheme (e.g.
+	// "Bearer [FILTERED]"), instead of omitting them. Applies even to headers missing from
+	// Allow, but not to denied headers.
+	RedactSensitive bool
+}
+
+// headerSets is the compiled form of a HeaderPolicy, using canonical header names.
+type headerSets struct {
+	allow  map[string]bool
+	deny   map[string]bool
+	redact map[string]bool
+}
+
+func newHeaderSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+	return set
+}
+
+func (p HeaderPolicy) compile() headerSets {
+	return headerSets{
+		allow:  newHeaderSet(p.Allow),
+		deny:   newHeaderSet(p.Deny),
+		redact: newHeaderSet(p.Redact),
+	}
 }
 
 type middleware struct {
 	MiddlewareOptions
-	agent   *Agent
-	handler http.Handler
+	agent         *Agent
+	handler       http.Handler
+	headers       headerSets
+	redactCookies map[string]bool
+	bots          []*regexp.Regexp
 }
 
 // NewHandler can be used to wrap any standard http.Handler. It handles panics caused by
 // the next handler in the chain by logging an error message to os.Stderr and sending the
 // same message to logjam. If the handler hasn't already written something to the response
-// writer, or set its response code, it will write a 500 response with an empty response
-// body. If the middleware option BubblePanics is true, it will panic again with the
-// original object.
+// writer, or set its response code, it will write a 500 response (or the PanicStatusCode
+// option) with an empty response body, unless the middleware option PanicResponse is set.
+// If the middleware option BubblePanics is true, it will panic again with the original
+// object.
 func (a *Agent) NewHandler(handler http.Handler, options MiddlewareOptions) http.Handler {
-	return &middleware{agent: a, handler: handler, MiddlewareOptions: options}
+	m := &middleware{agent: a, handler: handler, MiddlewareOptions: options, headers: options.Headers.compile()}
+	m.redactCookies = make(map[string]bool, len(options.RedactCookies))
+	for _, name := range options.RedactCookies {
+		m.redactCookies[name] = true
+	}
+	for _, pattern := range options.BotUserAgents {
+		matcher, err := regexp.Compile(pattern)
+		if err != nil {
+			a.Logger.Println("logjam: ignoring invalid bot user agent pattern:", err)
+			continue
+		}
+		m.bots = append(m.bots, matcher)
+	}
+	return m
 }
 
 // NewMiddleware is a convenience function to be used with the gorilla/mux package.
@@ -39,47 +179,129 @@ func (a *Agent) NewMiddleware(options MiddlewareOptions) func(http.Handler) http
 }
 
 func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	action := m.agent.ActionNameExtractor(r)
-	logjamRequest := m.agent.NewRequest(action)
+	m.serve(w, r, m.handler)
+}
+
+// NegroniHandler is a logjam middleware for the negroni package, whose middleware gets the
+// next handler passed on each call. Create it using Agent.NewNegroniHandler.
+type NegroniHandler struct {
+	m *middleware
+}
+
+// NewNegroniHandler returns a logjam middleware which can be added to a negroni middleware
+// stack using n.Use(agent.NewNegroniHandler(options)). It behaves like the middleware
+// returned by NewHandler.
+func (a *Agent) NewNegroniHandler(options MiddlewareOptions) *NegroniHandler {
+	return &NegroniHandler{m: a.NewHandler(nil, options).(*middleware)}
+}
+
+// ServeHTTP implements the negroni.Handler interface.
+func (h *NegroniHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	h.m.serve(w, r, next)
+}
+
+func (m *middleware) serve(w http.ResponseWriter, r *http.Request, handler http.Handler) {
+	// Pass through ignored requests and requests already handled by an outer logjam
+	// middleware, e.g. when shared router setup code installs the middleware again.
+	if GetRequest(r.Context()) != nil || m.ignore(r) {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	action := m.agent.ActionNameExtractor(m.prefixedRequest(r))
+	logjamRequest := m.agent.newRequest(action, time.Now(), r)
+	if m.StreamSelector != nil {
+		logjamRequest.SetStream(m.StreamSelector(r))
+	}
 	r = logjamRequest.AugmentRequest(r)
+	if m.Logger != nil {
+		r = r.WithContext(m.Logger.NewContext(r.Context()))
+	}
 
 	logjamRequest.callerID = r.Header.Get("X-Logjam-Caller-Id")
 	logjamRequest.callerAction = r.Header.Get("X-Logjam-Action")
-	if traceID := r.Header.Get("X-Logjam-Trace-Id"); traceID != "" {
+	if traceID := m.agent.incomingTraceID(r); traceID != "" {
 		logjamRequest.traceID = traceID
+		logjamRequest.traceContext(r)
+	} else if m.B3 != 0 && m.agent.continuesTrace(r) {
+		if traceID, sampled := m.B3.b3TraceID(r); traceID != "" {
+			logjamRequest.traceID = traceID
+			logjamRequest.b3Sampled = sampled
+		}
 	}
+	logjamRequest.b3 = m.B3
 
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		host = ""
 	}
-	if m.agent.ObfuscateIPs {
-		logjamRequest.ip = obfuscateIP(host)
+	if userAgent := r.UserAgent(); m.ClassifyUserAgents && userAgent != "" {
+		class, name := classifyUserAgent(userAgent)
+		logjamRequest.SetField("ua_class", class)
+		if name != "" {
+			logjamRequest.SetField("ua_name", name)
+		}
+	}
+	if network := m.agent.callerNetwork(host); network != "" {
+		logjamRequest.SetField("caller_network", network)
+	}
+	if m.obfuscateIPs() {
+		logjamRequest.ip = m.agent.obfuscateIP(host)
 	} else {
 		logjamRequest.ip = host
 	}
 
+	logjamRequest.SetField("protocol", r.Proto)
+	if wait, ok := queueTime(r, logjamRequest.startTime); ok {
+		logjamRequest.SetField("wait_time", milliseconds(wait))
+	}
+
 	header := w.Header()
 	header.Set("X-Logjam-Request-Id", logjamRequest.id)
 	header.Set("X-Logjam-Action", logjamRequest.action)
 	header.Set("X-Logjam-Caller-Id", logjamRequest.callerID)
+	header.Set(m.agent.TraceHeader, logjamRequest.traceID)
+
+	m.bufferForm(r)
+	jsonBody := m.bufferJSON(r)
+	if size := requestSize(r); size > 0 {
+		logjamRequest.AddBytes("request_size", size)
+	}
 
 	var stats metrics
+	var watchdog *watchdog
+	if m.StackDumpThreshold > 0 {
+		watchdog = startWatchdog(logjamRequest, m.StackDumpThreshold)
+	}
 	defer func() {
-		if recovered := recover(); recovered != nil {
-			msg := fmt.Sprintf("%#v:\n%s", recovered, string(debug.Stack()))
-			logjamRequest.Log(FATAL, msg)
-			logjamRequest.info = requestInfo(r)
+		if watchdog != nil {
+			watchdog.stop()
+		}
+		if recovered := recover(); recovered == http.ErrAbortHandler {
+			// The handler aborted the response, usually because the client went away
+			// while streaming. net/http silently closes the connection for this panic,
+			// so there is no point in writing a response or logging a stack trace.
+			logjamRequest.addSoftException(clientDisconnectException)
+			logjamRequest.Log(WARN, "client disconnected: "+recovered.(error).Error())
+			stats.Code = StatusClientClosedRequest
+			m.finish(logjamRequest, r, jsonBody, &stats)
+			panic(recovered)
+		} else if recovered != nil {
+			var msg string
+			if m.PanicHandler != nil {
+				m.PanicHandler(recovered, logjamRequest)
+			} else {
+				msg = fmt.Sprintf("%#v:\n%s", recovered, string(debug.Stack()))
+				logjamRequest.Log(FATAL, msg)
+			}
 			if !stats.HeaderWritten {
-				w.WriteHeader(500)
-				stats.Code = 500
+				m.writePanicResponse(w, r, recovered, &stats)
 			}
-			logjamRequest.Finish(stats.Code)
+			m.finish(logjamRequest, r, jsonBody, &stats)
 			if m.BubblePanics {
 				// We assume that someone up the call chain will log the panic and don't
 				// send anything to our logger.
 				panic(recovered)
-			} else {
+			} else if msg != "" {
 				// We are in a dilemma here: if the user has already logged information
 				// regarding the panic, we will log the panic twice. OTOH, if it's a panic
 				// caused by an underlying library used by the program and we don't log
@@ -88,62 +310,373 @@ func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}()
-	captureMetrics(m.handler, w, r, &stats)
+	captureMetrics(handler, w, r, &stats)
+	if watchdog != nil {
+		watchdog.stop()
+	}
+	m.checkContext(logjamRequest, r, &stats)
 
-	logjamRequest.info = requestInfo(r)
+	if conn := stats.Hijacked; conn != nil {
+		// The handler took over the connection, e.g. for a WebSocket. The request is
+		// finished when the connection gets closed. It isn't pooled, as goroutines
+		// serving the connection may still record metrics on it while it gets finished.
+		logjamRequest.SetField("hijacked", true)
+		logjamRequest.mutex.Lock()
+		logjamRequest.unpooled = true
+		logjamRequest.mutex.Unlock()
+		go func() {
+			<-conn.closed
+			logjamRequest.SetField("connection_duration", milliseconds(conn.duration()))
+			m.finish(logjamRequest, r, jsonBody, &stats)
+		}()
+		return
+	}
+	m.finish(logjamRequest, r, jsonBody, &stats)
+}
+
+// queueTime returns the time the request spent queued in front of the application, as
+// derived from the X-Request-Start or X-Queue-Start header set by proxies like nginx or
+// haproxy. Timestamps may be given in seconds, milliseconds or microseconds since the
+// epoch, optionally prefixed by "t=". The wait time is sent as a field rather than a time
+// metric, since it isn't part of the total time of the request.
+func queueTime(r *http.Request, start time.Time) (time.Duration, bool) {
+	value := r.Header.Get("X-Request-Start")
+	if value == "" {
+		value = r.Header.Get("X-Queue-Start")
+	}
+	value = strings.TrimPrefix(strings.TrimSpace(value), "t=")
+	if value == "" {
+		return 0, false
+	}
+	var queued time.Time
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		switch {
+		case n > 1e15:
+			queued = time.Unix(0, n*int64(time.Microsecond))
+		case n > 1e12:
+			queued = time.Unix(0, n*int64(time.Millisecond))
+		default:
+			queued = time.Unix(n, 0)
+		}
+	} else if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		queued = time.Unix(0, int64(seconds*float64(time.Second)))
+	} else {
+		return 0, false
+	}
+	wait := start.Sub(queued)
+	if wait < 0 || queued.Unix() <= 0 {
+		return 0, false
+	}
+	return wait, true
+}
+
+// ignore returns whether the request should bypass the logjam middleware, according to
+// the Ignore and IgnorePaths options.
+func (m *middleware) ignore(r *http.Request) bool {
+	if m.Ignore != nil && m.Ignore(r) {
+		return true
+	}
+	if m.IgnorePreflights && isPreflight(r) {
+		return true
+	}
+	if (m.IgnoreHeadRequests && r.Method == http.MethodHead) || m.isBot(r) {
+		atomic.AddUint64(&m.agent.filteredRequests, 1)
+		return true
+	}
+	p := r.URL.Path
+	for _, pattern := range m.IgnorePaths {
+		if strings.ContainsAny(pattern, "*?[") {
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+		} else if strings.HasPrefix(p, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixedRequest returns a shallow copy of the request with the PathPrefix option
+// prepended to its path, restoring the path seen by clients for action name extraction
+// when a mount prefix has been stripped.
+func (m *middleware) prefixedRequest(r *http.Request) *http.Request {
+	if m.PathPrefix == "" {
+		return r
+	}
+	prefix := strings.TrimSuffix(m.PathPrefix, "/")
+	u := *r.URL
+	u.Path = prefix + u.Path
+	if u.RawPath != "" {
+		u.RawPath = prefix + u.RawPath
+	}
+	prefixed := r.WithContext(r.Context())
+	prefixed.URL = &u
+	return prefixed
+}
+
+// DefaultBotUserAgents matches the User-Agents of common crawlers, monitoring services and
+// link preview generators.
+var DefaultBotUserAgents = []string{
+	`(?i)bot\b`, `(?i)crawler`, `(?i)spider`, `(?i)slurp`, `(?i)facebookexternalhit`,
+	`(?i)headlesschrome`, `(?i)pingdom`, `(?i)uptimerobot`,
+}
+
+// isBot returns whether the User-Agent of the given request matches one of the bot
+// patterns.
+func (m *middleware) isBot(r *http.Request) bool {
+	if len(m.bots) == 0 {
+		return false
+	}
+	userAgent := r.UserAgent()
+	for _, matcher := range m.bots {
+		if matcher.MatchString(userAgent) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilteredRequests returns the number of HEAD and bot requests which were not sent to
+// logjam because of the IgnoreHeadRequests and BotUserAgents middleware options.
+func (a *Agent) FilteredRequests() uint64 {
+	return atomic.LoadUint64(&a.filteredRequests)
+}
+
+// isPreflight returns whether the given request is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// sampleRate returns the fraction of successful requests for the given action which are
+// sent to logjam. The decision is made when the request is finished, so that it is based
+// on the final action name. Failed requests are always sent.
+func (m *middleware) sampleRate(action string) float64 {
+	rate, found := m.ActionSampleRates[action]
+	if !found {
+		rate = m.SampleRate
+	}
+	if rate <= 0 || rate > 1 {
+		return 1
+	}
+	return rate
+}
+
+// writePanicResponse writes the response for a panicking handler using the PanicResponse
+// hook and PanicStatusCode option, and records it in the given metrics.
+func (m *middleware) writePanicResponse(w http.ResponseWriter, r *http.Request, recovered interface{}, stats *metrics) {
+	code := m.PanicStatusCode
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+	if m.PanicResponse == nil {
+		w.WriteHeader(code)
+		stats.Code = code
+		return
+	}
+	var response metrics
+	captureMetricsFn(w, func(ww http.ResponseWriter) {
+		m.PanicResponse(ww, r, code, recovered)
+	}, &response)
+	stats.Code = response.Code
+	stats.Written += response.Written
+}
+
+// finish adds information about the HTTP request and the response to the logjam request
+// and sends it to logjam. The response size and a counter for the class of the response
+// code (response_2xx, response_4xx, ...) are recorded as metrics.
+func (m *middleware) finish(logjamRequest *Request, r *http.Request, jsonBody map[string]interface{}, stats *metrics) {
+	logjamRequest.mutex.Lock()
+	if logjamRequest.routeTemplate != "" && strings.HasPrefix(logjamRequest.action, "Unknown#") {
+		logjamRequest.action = RouteTemplateActionName(r.Method, logjamRequest.routeTemplate)
+	}
+	logjamRequest.mutex.Unlock()
+	if m.BeforeFinish != nil {
+		m.BeforeFinish(r, logjamRequest, stats.Code)
+	}
+	rate := m.sampleRate(logjamRequest.action)
+	if logjamRequest.sampleRate > 0 {
+		rate = logjamRequest.sampleRate
+	}
+	if m.PreflightSampleRate > 0 && m.PreflightSampleRate < rate && isPreflight(r) {
+		rate = m.PreflightSampleRate
+	}
+	if rate < 1 {
+		logjamRequest.sampled = logjamRequest.sampled && rand.Float64() < rate
+		logjamRequest.SetField("sample_rate", rate)
+	}
+	if m.TagServerErrors && stats.Code >= 500 {
+		logjamRequest.mutex.Lock()
+		untagged := len(logjamRequest.exceptions) == 0
+		logjamRequest.mutex.Unlock()
+		if untagged {
+			logjamRequest.AddException(serverErrorException(stats.Code))
+		}
+	}
+	if m.StatusSeverity != nil {
+		logjamRequest.raiseSeverity(m.StatusSeverity(stats.Code))
+	}
+	if mediaType := strings.TrimSpace(strings.SplitN(stats.ContentType, ";", 2)[0]); mediaType != "" {
+		logjamRequest.SetField("content_type", strings.ToLower(mediaType))
+	}
+	if !stats.FirstByte.IsZero() {
+		logjamRequest.SetField("ttfb", milliseconds(stats.FirstByte.Sub(logjamRequest.startTime)))
+	}
+	m.streamed(logjamRequest, stats)
+	logjamRequest.info = m.requestInfo(r, jsonBody)
+	logjamRequest.AddBytes(ResponseBytes, stats.Written)
+	logjamRequest.Count(fmt.Sprintf("response_%dxx", stats.Code/100))
 	logjamRequest.Finish(stats.Code)
 }
 
-func requestInfo(r *http.Request) map[string]interface{} {
+func (m *middleware) requestInfo(r *http.Request, jsonBody map[string]interface{}) map[string]interface{} {
 	info := map[string]interface{}{
 		"method": r.Method,
-		"url":    r.URL.String(),
+		"url":    m.agent.filteredURL(r.URL),
 	}
-	if headers := requestHeaders(r); len(headers) > 0 {
+	if headers := m.requestHeaders(r); len(headers) > 0 {
 		info["headers"] = headers
 	}
-	if query := queryParameters(r); len(query) > 0 {
+	if cookies := m.requestCookies(r); len(cookies) > 0 {
+		info["cookies"] = cookies
+	}
+	if query := m.agent.queryParameters(r); len(query) > 0 {
 		info["query_parameters"] = query
 	}
-	if body := bodyParameters(r); len(body) > 0 {
+	body := m.agent.bodyParameters(r)
+	for key, val := range jsonBody {
+		body[key] = val
+	}
+	if len(body) > 0 {
 		info["body_parameters"] = body
 	}
+	if r.TLS != nil {
+		info["tls"] = tlsInfo(r.TLS)
+	}
 	return info
 }
 
-func bodyParameters(r *http.Request) map[string]interface{} {
-	bodyParameters := map[string]interface{}{}
-	if r.MultipartForm == nil {
-		return bodyParameters
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// tlsInfo returns negotiated protocol version, cipher suite and server name of a TLS
+// connection.
+func tlsInfo(state *tls.ConnectionState) map[string]interface{} {
+	version, found := tlsVersions[state.Version]
+	if !found {
+		version = fmt.Sprintf("0x%04X", state.Version)
 	}
-	for key, values := range r.MultipartForm.Value {
-		if len(values) == 1 {
-			bodyParameters[key] = values[0]
-		} else {
-			bodyParameters[key] = values
+	info := map[string]interface{}{
+		"version":      version,
+		"cipher_suite": tls.CipherSuiteName(state.CipherSuite),
+	}
+	if state.ServerName != "" {
+		info["server_name"] = state.ServerName
+	}
+	if state.NegotiatedProtocol != "" {
+		info["alpn"] = state.NegotiatedProtocol
+	}
+	return info
+}
+
+func (a *Agent) bodyParameters(r *http.Request) map[string]interface{} {
+	values := url.Values{}
+	for key, v := range r.PostForm {
+		values[key] = v
+	}
+	if r.MultipartForm != nil {
+		for key, v := range r.MultipartForm.Value {
+			values[key] = v
 		}
 	}
-	return bodyParameters
+	return a.parameters(values)
+}
+
+func (a *Agent) queryParameters(r *http.Request) map[string]interface{} {
+	return a.parameters(r.URL.Query())
 }
 
-func queryParameters(r *http.Request) map[string]interface{} {
-	queryParameters := map[string]interface{}{}
-	for key, values := range r.URL.Query() {
-		if len(values) == 1 {
-			queryParameters[key] = values[0]
+// filteredParameter replaces the values of parameters matching the FilterParameters
+// option of the agent.
+const filteredParameter = "[FILTERED]"
+
+func (a *Agent) parameters(values map[string][]string) map[string]interface{} {
+	parameters := map[string]interface{}{}
+	for key, values := range values {
+		if a.filterParameter(key) {
+			parameters[key] = filteredParameter
+		} else if len(values) == 1 {
+			parameters[key] = values[0]
 		} else {
-			queryParameters[key] = values
+			parameters[key] = values
 		}
 	}
-	return queryParameters
+	return parameters
+}
+
+// filterParameter returns whether the value of the given parameter must be filtered. Like
+// in Rails, a parameter is filtered if its name contains one of the configured filter
+// parameters, ignoring case.
+func (a *Agent) filterParameter(key string) bool {
+	key = strings.ToLower(key)
+	for _, filter := range a.filterParameters {
+		if strings.Contains(key, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// filteredURL returns the string representation of the given URL with the values of
+// filtered query parameters replaced.
+func (a *Agent) filteredURL(u *url.URL) string {
+	query := u.Query()
+	filtered := false
+	for key := range query {
+		if a.filterParameter(key) {
+			query[key] = []string{filteredParameter}
+			filtered = true
+		}
+	}
+	if !filtered {
+		return u.String()
+	}
+	c := *u
+	c.RawQuery = query.Encode()
+	return c.String()
 }
 
 var hiddenHeaders = regexp.MustCompile(`\A(Server|Path|Gateway|Request|Script|Remote|Query|Passenger|Document|Scgi|Union[_-]Station|Original[_-]|Routes[_-]|Raw[_-]Post[_-]Data|(Http[_-])?Authorization)`)
 
-func requestHeaders(r *http.Request) map[string]string {
+var sensitiveHeaders = regexp.MustCompile(`\A(Http[_-])?(Proxy[_-])?Authorization\z`)
+
+// redactCredentials replaces the credentials of an authorization header value, keeping the
+// authentication scheme if there is one.
+func redactCredentials(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return filteredParameter
+	}
+	return fields[0] + " " + filteredParameter
+}
+
+func (m *middleware) requestHeaders(r *http.Request) map[string]string {
 	headers := map[string]string{}
 	for key, values := range r.Header {
-		if ignoredHeader(r, key) {
+		if m.headers.redact[key] {
+			headers[key] = filteredParameter
+			continue
+		}
+		if sensitiveHeaders.MatchString(key) {
+			if m.Headers.RedactSensitive && !m.headers.deny[key] {
+				headers[key] = redactCredentials(values[0])
+			}
+			continue
+		}
+		if m.ignoredHeader(r, key) {
 			continue
 		}
 		// ignore double set headers since Logjam can't handle them.
@@ -153,28 +686,94 @@ func requestHeaders(r *http.Request) map[string]string {
 	return headers
 }
 
-func ignoredHeader(r *http.Request, name string) bool {
+func (m *middleware) requestCookies(r *http.Request) map[string]string {
+	cookies := map[string]string{}
+	if !m.Cookies {
+		return cookies
+	}
+	for _, cookie := range r.Cookies() {
+		if m.redactCookies[cookie.Name] {
+			cookies[cookie.Name] = filteredParameter
+		} else {
+			cookies[cookie.Name] = cookie.Value
+		}
+	}
+	return cookies
+}
+
+func (m *middleware) ignoredHeader(r *http.Request, name string) bool {
 	return hiddenHeaders.MatchString(name) ||
-		(name == "Content-Length" && r.ContentLength <= 0)
+		(m.Cookies && name == "Cookie") ||
+		(name == "Content-Length" && r.ContentLength <= 0) ||
+		m.headers.deny[name] ||
+		(len(m.headers.allow) > 0 && !m.headers.allow[name])
+}
+
+// obfuscateIPs returns whether the middleware obfuscates client IPs, which defaults to the
+// agent option of the same name.
+func (m *middleware) obfuscateIPs() bool {
+	if m.ObfuscateIPs != nil {
+		return *m.ObfuscateIPs
+	}
+	return m.agent.ObfuscateIPs
 }
 
-var ipv4Mask = net.CIDRMask(24, 32)
-var ipv4Replacer = regexp.MustCompile(`0+\z`)
-var ipv6Mask = net.CIDRMask(112, 128)
+// obfuscateIP obfuscates the given IP address using the IPObfuscator option of the agent
+// or, by default, by masking it according to the IPv4MaskBits and IPv6MaskBits options.
+func (a *Agent) obfuscateIP(ip string) string {
+	if a.IPObfuscator != nil {
+		return a.IPObfuscator(ip)
+	}
+	return maskIP(ip, a.IPv4MaskBits, a.IPv6MaskBits)
+}
 
-func obfuscateIP(ip string) string {
+// maskIP keeps the given number of leading bits of an IPv4 or IPv6 address and replaces
+// octets (IPv4) or groups (IPv6) which are masked completely by XXX or XXXX, respectively.
+// Invalid addresses are returned unchanged.
+func maskIP(ip string, v4bits, v6bits int) string {
 	parsed := net.ParseIP(ip)
 	if parsed == nil {
 		return ip
-	} else if v4 := parsed.To4(); v4 != nil {
-		masked := v4.Mask(ipv4Mask).String()
-		return ipv4Replacer.ReplaceAllString(masked, "XXX")
-	} else if v6 := parsed.To16(); v6 != nil {
-		masked := v6.Mask(ipv6Mask).String()
-		return ipv4Replacer.ReplaceAllString(masked, "XXXX")
 	}
-
-	return ip
+	if v4 := parsed.To4(); v4 != nil {
+		masked := v4.Mask(net.CIDRMask(v4bits, 32))
+		octets := make([]string, 4)
+		for i, b := range masked {
+			if i*8 >= v4bits {
+				octets[i] = "XXX"
+			} else {
+				octets[i] = strconv.Itoa(int(b))
+			}
+		}
+		return strings.Join(octets, ".")
+	}
+	masked := parsed.Mask(net.CIDRMask(v6bits, 128))
+	groups := make([]string, 8)
+	for i := range groups {
+		if i*16 >= v6bits {
+			groups[i] = "XXXX"
+		} else {
+			groups[i] = strconv.FormatUint(uint64(masked[2*i])<<8|uint64(masked[2*i+1]), 16)
+		}
+	}
+	// Compress the longest run of zero groups, as net.IP.String does.
+	start, length := 0, 0
+	for i := 0; i < len(groups); i++ {
+		j := i
+		for j < len(groups) && groups[j] == "0" {
+			j++
+		}
+		if j-i > length {
+			start, length = i, j-i
+		}
+		if j > i {
+			i = j
+		}
+	}
+	if length < 2 {
+		return strings.Join(groups, ":")
+	}
+	return strings.Join(groups[:start], ":") + "::" + strings.Join(groups[start+length:], ":")
 }
 
 func ipv4for(host string) (net.IP, error) {
@@ -199,15 +798,116 @@ func ipv4for(host string) (net.IP, error) {
 }
 
 // SetCallHeaders makes sure all X-Logjam-* Headers are copied into the outgoing
-// request. Call this before you call other APIs.
+// request. The trace id is sent using the trace header configured on the agent and, if it
+// is a valid W3C trace id, as a traceparent header along with the incoming tracestate. B3
+// headers are added if enabled via MiddlewareOptions. Call this before you call other APIs.
 func SetCallHeaders(ctx context.Context, outgoing *http.Request) {
-	incoming := GetRequest(ctx)
-	if incoming == nil {
+	headers := CallHeaders(ctx)
+	if headers == nil {
 		return
 	}
 	if outgoing.Header == nil {
 		outgoing.Header = http.Header{}
 	}
-	outgoing.Header.Set("X-Logjam-Caller-Id", incoming.id)
-	outgoing.Header.Set("X-Logjam-Action", incoming.action)
+	for name, values := range headers {
+		outgoing.Header[name] = values
+	}
+}
+
+// CallHeaders returns the headers SetCallHeaders adds to outgoing requests, for use with
+// protocols other than HTTP. Returns nil if the context has no logjam request.
+func CallHeaders(ctx context.Context) http.Header {
+	incoming := GetRequest(ctx)
+	if incoming == nil {
+		return nil
+	}
+	header := http.Header{}
+	header.Set("X-Logjam-Caller-Id", incoming.id)
+	header.Set("X-Logjam-Action", incoming.action)
+	header.Set(incoming.agent.TraceHeader, incoming.TraceID())
+	if traceparent := incoming.traceparent(); traceparent != "" {
+		header.Set("traceparent", traceparent)
+		if incoming.traceState != "" {
+			header.Set("tracestate", incoming.traceState)
+		}
+	}
+	incoming.setB3Headers(header)
+	return header
+}
+
+// checkContext tags requests whose context ended while the handler was running. Requests
+// exceeding their deadline get a timeout exception and are reported with code 504, unless
+// the handler has written a response. Requests of clients which went away get a soft
+// client_gone exception and are reported with code 499, unless the handler has written a
+// response.
+func (m *middleware) checkContext(logjamRequest *Request, r *http.Request, stats *metrics) {
+	if stats.Hijacked != nil {
+		return
+	}
+	switch r.Context().Err() {
+	case context.DeadlineExceeded:
+		logjamRequest.AddException(timeoutException)
+		logjamRequest.Log(ERROR, "request deadline exceeded")
+		if !stats.HeaderWritten {
+			stats.Code = http.StatusGatewayTimeout
+		}
+	case context.Canceled:
+		logjamRequest.addSoftException(clientGoneException)
+		logjamRequest.Log(WARN, "client went away")
+		if !stats.HeaderWritten {
+			stats.Code = StatusClientClosedRequest
+		}
+	}
+}
+
+// streamed marks requests running longer than the StreamingThreshold option, like server sent
+// events or long polls, as streamed. Streaming starts when the handler first flushed the
+// response, or after StreamingThreshold if it didn't flush in time. The time spent streaming
+// is reported as streaming_duration instead of being part of total_time, so that long
+// lived connections don't distort response time statistics. Hijacked connections, like
+// WebSockets, always stream from the time the connection was taken over.
+func (m *middleware) streamed(logjamRequest *Request, stats *metrics) {
+	if stats.Hijacked != nil {
+		logjamRequest.streamStart = stats.Hijacked.start
+		return
+	}
+	if m.StreamingThreshold <= 0 {
+		return
+	}
+	start := logjamRequest.startTime.Add(m.StreamingThreshold)
+	if time.Now().Before(start) {
+		return
+	}
+	if !stats.Flushed.IsZero() && stats.Flushed.Before(start) {
+		start = stats.Flushed
+	}
+	logjamRequest.streamStart = start
+	logjamRequest.SetField("streamed_bytes", bytesBucket(stats.Written))
+}
+
+// bytesBucket returns a coarse size class for the given number of bytes.
+func bytesBucket(n int64) string {
+	switch {
+	case n == 0:
+		return "0"
+	case n < 1<<10:
+		return "<1KB"
+	case n < 1<<20:
+		return "<1MB"
+	case n < 1<<30:
+		return "<1GB"
+	}
+	return ">=1GB"
+}
+
+// serverErrorException derives an exception tag from the status text of the given
+// response code, e.g. internal_server_error for 500.
+func serverErrorException(code int) string {
+	text := http.StatusText(code)
+	if text == "" {
+		return "server_error"
+	}
+	return strings.ToLower(strings.Join(strings.FieldsFunc(text, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	}), "_"))
 }
@@ -1,8 +1,14 @@
 package logjam
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -21,6 +27,7 @@ import (
 
 func TestObfuscateIP(t *testing.T) {
 	Convey("Obfuscate IP", t, func() {
+		agent := NewAgent(&Options{})
 		ips := map[string]string{
 			"0000:0000:0000:0000:0000:FFFF:C0A8:1": "192.168.0.XXX",
 			"192.168.0.1":                          "192.168.0.XXX",
@@ -40,9 +47,103 @@ func TestObfuscateIP(t *testing.T) {
 
 		for _, key := range keys {
 			Convey(key, func() {
-				So(obfuscateIP(key), ShouldEqual, ips[key])
+				So(agent.obfuscateIP(key), ShouldEqual, ips[key])
 			})
 		}
+
+		Convey("with custom mask lengths", func() {
+			agent := NewAgent(&Options{IPv4MaskBits: 16, IPv6MaskBits: 64})
+			So(agent.obfuscateIP("192.168.0.1"), ShouldEqual, "192.168.XXX.XXX")
+			So(agent.obfuscateIP("fe80::da50:e6ff:fedb:c252"), ShouldEqual, "fe80::XXXX:XXXX:XXXX:XXXX")
+			So(agent.obfuscateIP("2001:db8:0:1::1"), ShouldEqual, "2001:db8:0:1:XXXX:XXXX:XXXX:XXXX")
+		})
+
+		Convey("with a custom obfuscator", func() {
+			agent := NewAgent(&Options{IPObfuscator: func(ip string) string { return "hashed:" + ip }})
+			So(agent.obfuscateIP("192.168.0.1"), ShouldEqual, "hashed:192.168.0.1")
+		})
+	})
+}
+
+func TestObfuscateIPsOverride(t *testing.T) {
+	Convey("Overriding IP obfuscation per middleware", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), ObfuscateIPs: true})
+		defer agent.Shutdown()
+		obfuscate, keep := true, false
+
+		So(agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{}).(*middleware).obfuscateIPs(), ShouldBeTrue)
+		So(agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{ObfuscateIPs: &keep}).(*middleware).obfuscateIPs(), ShouldBeFalse)
+
+		agent = NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		So(agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{ObfuscateIPs: &obfuscate}).(*middleware).obfuscateIPs(), ShouldBeTrue)
+	})
+}
+
+func TestStreamSelector(t *testing.T) {
+	Convey("Selecting streams per request", t, func() {
+		socket, err := zmq4.NewSocket(zmq4.ROUTER)
+		So(err, ShouldBeNil)
+		So(socket.Bind("inproc://stream-selector-test"), ShouldBeNil)
+		defer socket.Close()
+
+		agent := NewAgent(&Options{
+			Endpoints: "inproc://stream-selector-test",
+			AppName:   "shop",
+			EnvName:   "production",
+			Logger:    log.New(ioutil.Discard, "", 0),
+		})
+		defer agent.Shutdown()
+		selector := func(r *http.Request) (string, string) {
+			if r.Host == "brand.example.com" {
+				return "brand", ""
+			}
+			return "", ""
+		}
+		handler := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{StreamSelector: selector})
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "http://brand.example.com/", nil))
+		So(rr.Header().Get("X-Logjam-Request-Id"), ShouldStartWith, "brand-production-")
+		msg, err := socket.RecvMessage(0)
+		So(err, ShouldBeNil)
+		So(msg[1], ShouldEqual, "brand-production")
+		So(msg[2], ShouldEqual, "logs.brand.production")
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil))
+		msg, err = socket.RecvMessage(0)
+		So(err, ShouldBeNil)
+		So(msg[1], ShouldEqual, "shop-production")
+	})
+}
+
+func TestStackDump(t *testing.T) {
+	Convey("Dumping the stack of stuck requests", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		var finished *Request
+		options := MiddlewareOptions{
+			StackDumpThreshold: 10 * time.Millisecond,
+			BeforeFinish:       func(r *http.Request, req *Request, code int) { finished = req },
+		}
+		stuck := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { time.Sleep(100 * time.Millisecond) })
+		agent.NewHandler(stuck, options).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		So(finished.logLines, ShouldHaveLength, 1)
+		So(finished.logLines[0].severity, ShouldEqual, ERROR)
+		So(finished.logLines[0].message, ShouldStartWith, "request still running after 10ms:\ngoroutine ")
+		So(finished.logLines[0].message, ShouldContainSubstring, "TestStackDump")
+
+		Convey("at most once per interval", func() {
+			agent.NewHandler(stuck, options).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			So(finished.logLines, ShouldHaveLength, 1)
+			So(finished.logLines[0].severity, ShouldEqual, ERROR)
+			So(finished.logLines[0].message, ShouldEqual, "request still running after 10ms (stack dumped less than 1m0s ago)")
+		})
+
+		Convey("but not of fast ones", func() {
+			agent.NewHandler(http.NotFoundHandler(), options).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			So(finished.logLines, ShouldBeEmpty)
+		})
 	})
 }
 
@@ -148,6 +249,7 @@ func TestMiddleware(t *testing.T) {
 		uuid := requestParts[len(requestParts)-1]
 		So(res.Header.Get("X-Logjam-Action"), ShouldEqual, actionName)
 		So(res.Header.Get("X-Logjam-Caller-Id"), ShouldEqual, callerID)
+		So(res.Header.Get("X-Logjam-Trace-Id"), ShouldEqual, traceID)
 		So(res.Header.Get("Http-Authorization"), ShouldEqual, "")
 
 		msg, err := socket.RecvMessage(0)
@@ -185,6 +287,11 @@ func TestMiddleware(t *testing.T) {
 		So(output["cluster"], ShouldEqual, "a")
 		So(output["namespace"], ShouldEqual, "logjam")
 		So(output["sender_id"], ShouldEqual, "foobar")
+		So(output["response_bytes"], ShouldEqual, len("some body"))
+		So(output["response_2xx"], ShouldEqual, 1)
+		So(output["ttfb"], ShouldBeGreaterThan, 0)
+		So(output["protocol"], ShouldEqual, "HTTP/1.1")
+		So(output["ttfb"], ShouldBeLessThanOrEqualTo, totalTime)
 
 		exceptions := output["exceptions"]
 		So(exceptions, ShouldHaveLength, 2)
@@ -331,6 +438,8 @@ func TestMiddleware(t *testing.T) {
 				So(output["datacenter"], ShouldEqual, "dc")
 				So(output["cluster"], ShouldEqual, "a")
 				So(output["namespace"], ShouldEqual, "logjam")
+				So(output["response_bytes"], ShouldEqual, 0)
+				So(output[fmt.Sprintf("response_%dxx", test.Code/100)], ShouldEqual, 1)
 
 				requestInfo := output["request_info"].(map[string]interface{})
 				So(requestInfo["method"], ShouldEqual, "GET")
@@ -350,6 +459,673 @@ func TestMiddleware(t *testing.T) {
 	})
 }
 
+func TestFilterParameters(t *testing.T) {
+	Convey("Filtering parameters", t, func() {
+		agent := NewAgent(&Options{
+			Logger:           log.New(ioutil.Discard, "", 0),
+			FilterParameters: []string{"password", "Token"},
+		})
+
+		r := httptest.NewRequest("POST", "/login?user=joe&access_token=secret", nil)
+		r.MultipartForm = &multipart.Form{Value: map[string][]string{
+			"user":                  {"joe"},
+			"password":              {"secret"},
+			"password_confirmation": {"secret"},
+		}}
+		m := &middleware{agent: agent}
+		info := m.requestInfo(r, nil)
+
+		So(info["url"], ShouldEqual, "/login?access_token=%5BFILTERED%5D&user=joe")
+		So(info["query_parameters"], ShouldResemble, map[string]interface{}{
+			"user":         "joe",
+			"access_token": "[FILTERED]",
+		})
+		So(info["body_parameters"], ShouldResemble, map[string]interface{}{
+			"user":                  "joe",
+			"password":              "[FILTERED]",
+			"password_confirmation": "[FILTERED]",
+		})
+
+		Convey("leaves the URL untouched if nothing is filtered", func() {
+			r := httptest.NewRequest("GET", "/users?b=1&a=2", nil)
+			So(m.requestInfo(r, nil)["url"], ShouldEqual, "/users?b=1&a=2")
+		})
+	})
+}
+
+func TestHeaderPolicy(t *testing.T) {
+	Convey("Header policy", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", "text/html")
+		r.Header.Set("User-Agent", "test")
+		r.Header.Set("Cookie", "session=123")
+		r.Header.Set("Authorization", "Bearer 123")
+
+		headers := func(policy HeaderPolicy) map[string]string {
+			handler := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{Headers: policy})
+			return handler.(*middleware).requestHeaders(r)
+		}
+
+		Convey("hides sensitive headers by default", func() {
+			So(headers(HeaderPolicy{}), ShouldResemble, map[string]string{
+				"Accept":     "text/html",
+				"User-Agent": "test",
+				"Cookie":     "session=123",
+			})
+		})
+
+		Convey("denies headers", func() {
+			So(headers(HeaderPolicy{Deny: []string{"cookie"}}), ShouldResemble, map[string]string{
+				"Accept":     "text/html",
+				"User-Agent": "test",
+			})
+		})
+
+		Convey("allows headers", func() {
+			So(headers(HeaderPolicy{Allow: []string{"accept", "authorization"}}), ShouldResemble, map[string]string{
+				"Accept": "text/html",
+			})
+		})
+
+		Convey("redacts headers", func() {
+			So(headers(HeaderPolicy{Allow: []string{"Accept"}, Redact: []string{"cookie", "Authorization"}}), ShouldResemble, map[string]string{
+				"Accept":        "text/html",
+				"Cookie":        "[FILTERED]",
+				"Authorization": "[FILTERED]",
+			})
+		})
+
+		Convey("redacts sensitive headers preserving the scheme", func() {
+			r.Header.Set("Proxy-Authorization", "secret")
+			So(headers(HeaderPolicy{Allow: []string{"Accept"}, RedactSensitive: true}), ShouldResemble, map[string]string{
+				"Accept":              "text/html",
+				"Authorization":       "Bearer [FILTERED]",
+				"Proxy-Authorization": "[FILTERED]",
+			})
+			So(headers(HeaderPolicy{}), ShouldNotContainKey, "Proxy-Authorization")
+		})
+	})
+}
+
+func TestCookies(t *testing.T) {
+	Convey("Capturing cookies", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", "text/html")
+		r.Header.Set("Cookie", "session=123; theme=dark")
+
+		Convey("is disabled by default", func() {
+			m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{}).(*middleware)
+			info := m.requestInfo(r, nil)
+			So(info, ShouldNotContainKey, "cookies")
+			So(info["headers"], ShouldContainKey, "Cookie")
+		})
+
+		Convey("redacts configured cookies", func() {
+			m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{Cookies: true, RedactCookies: []string{"session"}}).(*middleware)
+			info := m.requestInfo(r, nil)
+			So(info["cookies"], ShouldResemble, map[string]string{"session": "[FILTERED]", "theme": "dark"})
+			So(info["headers"], ShouldNotContainKey, "Cookie")
+		})
+	})
+}
+
+func TestPanicResponse(t *testing.T) {
+	Convey("Responding to panics", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+
+		Convey("uses the configured status code", func() {
+			rr := httptest.NewRecorder()
+			agent.NewHandler(panicking, MiddlewareOptions{PanicStatusCode: 503}).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+			So(rr.Code, ShouldEqual, 503)
+			So(rr.Body.String(), ShouldEqual, "")
+		})
+
+		Convey("uses the configured responder", func() {
+			responder := func(w http.ResponseWriter, r *http.Request, code int, recovered interface{}) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(code)
+				fmt.Fprintf(w, `{"error":%q}`, recovered)
+			}
+			rr := httptest.NewRecorder()
+			agent.NewHandler(panicking, MiddlewareOptions{PanicResponse: responder}).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+			So(rr.Code, ShouldEqual, 500)
+			So(rr.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			So(rr.Body.String(), ShouldEqual, `{"error":"boom"}`)
+		})
+	})
+}
+
+func TestPanicHandler(t *testing.T) {
+	Convey("Reporting panics", t, func() {
+		var output bytes.Buffer
+		agent := NewAgent(&Options{Logger: log.New(&output, "", 0)})
+		defer agent.Shutdown()
+		panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic(io.ErrUnexpectedEOF) })
+
+		var finished *Request
+		options := MiddlewareOptions{
+			PanicHandler: func(recovered interface{}, req *Request) {
+				req.AddException(fmt.Sprintf("%T", recovered))
+				req.Log(ERROR, fmt.Sprint(recovered))
+			},
+			BeforeFinish: func(r *http.Request, req *Request, code int) { finished = req },
+		}
+		rr := httptest.NewRecorder()
+		agent.NewHandler(panicking, options).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		So(rr.Code, ShouldEqual, 500)
+		So(finished.exceptions, ShouldResemble, map[string]int{"*errors.errorString": 1})
+		So(finished.logLines, ShouldHaveLength, 1)
+		So(finished.logLines[0].message, ShouldEqual, "unexpected EOF")
+		So(output.String(), ShouldEqual, "")
+	})
+}
+
+func TestIgnoreMiddlewareOption(t *testing.T) {
+	Convey("Ignoring requests", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		handler := agent.NewHandler(http.HandlerFunc(NotFoundHandler), MiddlewareOptions{
+			Ignore:      func(r *http.Request) bool { return r.Header.Get("X-Probe") != "" },
+			IgnorePaths: []string{"/health", "/assets/*.css"},
+		})
+		m := handler.(*middleware)
+
+		ignored := func(path string) bool {
+			return m.ignore(httptest.NewRequest("GET", path, nil))
+		}
+
+		So(ignored("/health"), ShouldBeTrue)
+		So(ignored("/healthz"), ShouldBeTrue)
+		So(ignored("/assets/main.css"), ShouldBeTrue)
+		So(ignored("/assets/main.js"), ShouldBeFalse)
+		So(ignored("/users"), ShouldBeFalse)
+
+		r := httptest.NewRequest("GET", "/users", nil)
+		r.Header.Set("X-Probe", "1")
+		So(m.ignore(r), ShouldBeTrue)
+
+		Convey("passes ignored requests to the handler", func() {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+			So(rr.Code, ShouldEqual, 404)
+			So(rr.Header().Get("X-Logjam-Request-Id"), ShouldEqual, "")
+		})
+	})
+}
+
+func TestMiddlewareSampling(t *testing.T) {
+	Convey("Sampling in the middleware", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{
+			SampleRate:        1e-12,
+			ActionSampleRates: map[string]float64{"Rare#get": 1, "Half#get": 0.5},
+		}).(*middleware)
+
+		So(m.sampleRate("Users#get"), ShouldEqual, 1e-12)
+		So(m.sampleRate("Half#get"), ShouldEqual, 0.5)
+		So(m.sampleRate("Rare#get"), ShouldEqual, 1)
+
+		Convey("records the sample rate", func() {
+			r := httptest.NewRequest("GET", "/users", nil)
+			logjamRequest := agent.NewRequest("Users#get")
+			m.finish(logjamRequest, r, nil, &metrics{Code: 200})
+			So(logjamRequest.Sampled(), ShouldBeFalse)
+			So(logjamRequest.GetField("sample_rate"), ShouldEqual, 1e-12)
+		})
+
+		Convey("doesn't sample actions with a rate of 1", func() {
+			r := httptest.NewRequest("GET", "/rare", nil)
+			logjamRequest := agent.NewRequest("Rare#get")
+			m.finish(logjamRequest, r, nil, &metrics{Code: 200})
+			So(logjamRequest.Sampled(), ShouldBeTrue)
+			So(logjamRequest.GetField("sample_rate"), ShouldBeNil)
+		})
+	})
+}
+
+func TestAbortHandler(t *testing.T) {
+	Convey("Aborted handlers", t, func() {
+		var output bytes.Buffer
+		agent := NewAgent(&Options{Logger: log.New(&output, "", 0)})
+		defer agent.Shutdown()
+		aborting := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			panic(http.ErrAbortHandler)
+		})
+
+		var finished *Request
+		var code int
+		options := MiddlewareOptions{
+			BeforeFinish: func(r *http.Request, req *Request, c int) { finished, code = req, c },
+		}
+		rr := httptest.NewRecorder()
+		So(func() { agent.NewHandler(aborting, options).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil)) }, ShouldPanicWith, http.ErrAbortHandler)
+		So(code, ShouldEqual, 499)
+		So(finished.exceptions, ShouldResemble, map[string]int{"client_disconnect": 1})
+		So(finished.severity, ShouldEqual, WARN)
+		So(finished.logLines, ShouldHaveLength, 1)
+		So(output.String(), ShouldEqual, "")
+	})
+}
+
+func TestHijackedConnections(t *testing.T) {
+	Convey("Hijacked connections", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), PoolRequests: true})
+		defer agent.Shutdown()
+		release := make(chan struct{})
+		hijacking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				panic(err)
+			}
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+			rw.Flush()
+			go func() {
+				<-release
+				conn.Close()
+			}()
+		})
+
+		finished := make(chan *Request, 1)
+		var code int
+		options := MiddlewareOptions{
+			BeforeFinish: func(r *http.Request, req *Request, c int) {
+				code = c
+				finished <- req
+			},
+		}
+		server := httptest.NewServer(agent.NewHandler(hijacking, options))
+		defer server.Close()
+
+		res, err := http.Get(server.URL)
+		So(err, ShouldBeNil)
+		res.Body.Close()
+		So(res.StatusCode, ShouldEqual, 101)
+
+		select {
+		case <-finished:
+			t.Fatal("request finished before the connection was closed")
+		case <-time.After(10 * time.Millisecond):
+		}
+		close(release)
+		req := <-finished
+		So(code, ShouldEqual, 101)
+		So(req.GetField("hijacked"), ShouldEqual, true)
+		So(req.GetField("connection_duration"), ShouldBeGreaterThanOrEqualTo, 10.0)
+	})
+
+	Convey("Hijacked connections stream from the time they were taken over", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		m := agent.NewHandler(nil, MiddlewareOptions{}).(*middleware)
+		req := agent.NewRequestAt("WebSockets#connect", time.Now().Add(-time.Second))
+		stats := &metrics{Hijacked: newHijackedConn(nil)}
+		m.streamed(req, stats)
+		So(req.streamStart, ShouldEqual, stats.Hijacked.start)
+	})
+}
+
+func TestStatusSeverity(t *testing.T) {
+	Convey("Severity by response code", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		r := httptest.NewRequest("GET", "/", nil)
+
+		severity := func(options MiddlewareOptions, code int) LogLevel {
+			m := agent.NewHandler(http.NotFoundHandler(), options).(*middleware)
+			logjamRequest := agent.NewRequest("Users#get")
+			m.finish(logjamRequest, r, nil, &metrics{Code: code})
+			return logjamRequest.severity
+		}
+
+		options := MiddlewareOptions{StatusSeverity: DefaultStatusSeverity}
+		So(severity(options, 200), ShouldEqual, INFO)
+		So(severity(options, 404), ShouldEqual, WARN)
+		So(severity(options, 503), ShouldEqual, ERROR)
+		So(severity(MiddlewareOptions{}, 503), ShouldEqual, INFO)
+	})
+}
+
+func TestTagServerErrors(t *testing.T) {
+	Convey("Tagging server errors", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{TagServerErrors: true}).(*middleware)
+		r := httptest.NewRequest("GET", "/", nil)
+
+		exceptions := func(code int, existing ...string) map[string]int {
+			logjamRequest := agent.NewRequest("Users#get")
+			for _, name := range existing {
+				logjamRequest.AddException(name)
+			}
+			m.finish(logjamRequest, r, nil, &metrics{Code: code})
+			return logjamRequest.exceptions
+		}
+
+		So(exceptions(200), ShouldBeEmpty)
+		So(exceptions(500), ShouldResemble, map[string]int{"internal_server_error": 1})
+		So(exceptions(504), ShouldResemble, map[string]int{"gateway_timeout": 1})
+		So(exceptions(599), ShouldResemble, map[string]int{"server_error": 1})
+		So(exceptions(500, "DatabaseError"), ShouldResemble, map[string]int{"DatabaseError": 1})
+	})
+}
+
+func TestContextErrors(t *testing.T) {
+	Convey("Requests ending with a context error", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+
+		var finished *Request
+		var code int
+		options := MiddlewareOptions{
+			BeforeFinish: func(r *http.Request, req *Request, c int) { finished, code = req, c },
+		}
+		waiting := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-r.Context().Done() })
+
+		Convey("deadline exceeded", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+			agent.NewHandler(waiting, options).ServeHTTP(httptest.NewRecorder(), r)
+			So(code, ShouldEqual, 504)
+			So(finished.exceptions, ShouldResemble, map[string]int{"timeout": 1})
+			So(finished.severity, ShouldEqual, ERROR)
+		})
+
+		Convey("client gone", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+			agent.NewHandler(waiting, options).ServeHTTP(httptest.NewRecorder(), r)
+			So(code, ShouldEqual, 499)
+			So(finished.exceptions, ShouldResemble, map[string]int{"client_gone": 1})
+			So(finished.severity, ShouldEqual, WARN)
+		})
+
+		Convey("client gone after the response was written", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+			writing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("OK"))
+				cancel()
+			})
+			agent.NewHandler(writing, options).ServeHTTP(httptest.NewRecorder(), r)
+			So(code, ShouldEqual, 200)
+			So(finished.exceptions, ShouldResemble, map[string]int{"client_gone": 1})
+		})
+
+		Convey("completed", func() {
+			agent.NewHandler(http.NotFoundHandler(), options).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			So(code, ShouldEqual, 404)
+			So(finished.exceptions, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestNestedMiddleware(t *testing.T) {
+	Convey("Nested middleware", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+
+		var inner, outer int
+		var seen *Request
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = GetRequest(r.Context()) })
+		innerHandler := agent.NewHandler(handler, MiddlewareOptions{BeforeFinish: func(*http.Request, *Request, int) { inner++ }})
+		outerHandler := agent.NewHandler(innerHandler, MiddlewareOptions{BeforeFinish: func(r *http.Request, req *Request, code int) {
+			outer++
+			So(req, ShouldEqual, seen)
+		}})
+		outerHandler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		So(outer, ShouldEqual, 1)
+		So(inner, ShouldEqual, 0)
+		So(seen, ShouldNotBeNil)
+	})
+}
+
+func TestContentType(t *testing.T) {
+	Convey("Response content type", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+
+		contentType := func(handler http.HandlerFunc) interface{} {
+			var finished *Request
+			options := MiddlewareOptions{BeforeFinish: func(r *http.Request, req *Request, code int) { finished = req }}
+			agent.NewHandler(handler, options).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			return finished.GetField("content_type")
+		}
+
+		So(contentType(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/JSON; charset=utf-8")
+			w.WriteHeader(200)
+		}), ShouldEqual, "application/json")
+		So(contentType(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<!DOCTYPE html><html></html>"))
+		}), ShouldEqual, "text/html")
+		So(contentType(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(204)
+		}), ShouldBeNil)
+	})
+}
+
+func TestBeforeFinish(t *testing.T) {
+	Convey("BeforeFinish hook", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		var code int
+		m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{
+			BeforeFinish: func(r *http.Request, req *Request, c int) {
+				code = c
+				req.SetField("tenant", r.Header.Get("X-Tenant"))
+			},
+		}).(*middleware)
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Tenant", "acme")
+		logjamRequest := agent.NewRequest("Users#get")
+		m.finish(logjamRequest, r, nil, &metrics{Code: 404})
+		So(code, ShouldEqual, 404)
+		So(logjamRequest.GetField("tenant"), ShouldEqual, "acme")
+	})
+}
+
+func TestPreflights(t *testing.T) {
+	Convey("CORS preflight requests", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		preflight := httptest.NewRequest("OPTIONS", "/users", nil)
+		preflight.Header.Set("Access-Control-Request-Method", "POST")
+		options := httptest.NewRequest("OPTIONS", "/users", nil)
+
+		Convey("can be ignored", func() {
+			m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{IgnorePreflights: true}).(*middleware)
+			So(m.ignore(preflight), ShouldBeTrue)
+			So(m.ignore(options), ShouldBeFalse)
+		})
+
+		Convey("can be sampled", func() {
+			m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{PreflightSampleRate: 1e-12}).(*middleware)
+			logjamRequest := agent.NewRequest("Users#options")
+			m.finish(logjamRequest, preflight, nil, &metrics{Code: 204})
+			So(logjamRequest.Sampled(), ShouldBeFalse)
+			So(logjamRequest.GetField("sample_rate"), ShouldEqual, 1e-12)
+
+			logjamRequest = agent.NewRequest("Users#options")
+			m.finish(logjamRequest, options, nil, &metrics{Code: 204})
+			So(logjamRequest.Sampled(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestHeadAndBotFiltering(t *testing.T) {
+	Convey("Filtering HEAD and bot requests", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{
+			IgnoreHeadRequests: true,
+			BotUserAgents:      append(DefaultBotUserAgents, "["),
+		}).(*middleware)
+		So(m.bots, ShouldHaveLength, len(DefaultBotUserAgents))
+
+		request := func(method, userAgent string) *http.Request {
+			r := httptest.NewRequest(method, "/", nil)
+			r.Header.Set("User-Agent", userAgent)
+			return r
+		}
+
+		So(m.ignore(request("HEAD", "curl/7.64.1")), ShouldBeTrue)
+		So(m.ignore(request("GET", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")), ShouldBeTrue)
+		So(m.ignore(request("GET", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0 Safari/605.1.15")), ShouldBeFalse)
+		So(m.ignore(request("GET", "Robotics Client")), ShouldBeFalse)
+		So(agent.FilteredRequests(), ShouldEqual, 2)
+	})
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	Convey("Loggers stored in the request context", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+
+		var output bytes.Buffer
+		logger := &Logger{Logger: log.New(&output, "", 0), LogLevel: INFO}
+		var finished *Request
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			LoggerFromContext(r.Context()).Info(r.Context(), "hello")
+		})
+		options := MiddlewareOptions{
+			Logger:       logger,
+			BeforeFinish: func(r *http.Request, req *Request, code int) { finished = req },
+		}
+		agent.NewHandler(handler, options).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		So(output.String(), ShouldEqual, "hello\n")
+		So(finished.logLines, ShouldHaveLength, 1)
+
+		Convey("falls back to logging to logjam only", func() {
+			options.Logger = nil
+			agent.NewHandler(handler, options).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			So(finished.logLines, ShouldHaveLength, 1)
+			So(LoggerFromContext(context.Background()), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRouteTemplateFallback(t *testing.T) {
+	Convey("Falling back to route templates", t, func() {
+		agent := NewAgent(&Options{
+			Logger:              log.New(ioutil.Discard, "", 0),
+			ActionNameExtractor: func(r *http.Request) string { return "Unknown#get" },
+		})
+		defer agent.Shutdown()
+		m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{}).(*middleware)
+		r := httptest.NewRequest("GET", "/users/123", nil)
+
+		logjamRequest := agent.NewRequest("Unknown#get")
+		logjamRequest.SetRouteTemplate("/users/{id}")
+		m.finish(logjamRequest, r, nil, &metrics{Code: 200})
+		So(logjamRequest.action, ShouldEqual, "Users::Id#get")
+
+		logjamRequest = agent.NewRequest("Users#show")
+		logjamRequest.SetRouteTemplate("/users/{id}")
+		m.finish(logjamRequest, r, nil, &metrics{Code: 200})
+		So(logjamRequest.action, ShouldEqual, "Users#show")
+	})
+}
+
+func TestPathPrefix(t *testing.T) {
+	Convey("Prepending a path prefix for action name extraction", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		var path string
+		h := agent.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+		}), MiddlewareOptions{PathPrefix: "/api/v2/"})
+		h = http.StripPrefix("/api/v2", h)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/users/123", nil))
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Api::V2::Users::Id#get")
+		So(path, ShouldEqual, "/users/123")
+	})
+}
+
+func TestTLSInfo(t *testing.T) {
+	Convey("TLS connection details", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		m := agent.NewHandler(http.NotFoundHandler(), MiddlewareOptions{}).(*middleware)
+
+		So(m.requestInfo(httptest.NewRequest("GET", "http://example.com/", nil), nil)["tls"], ShouldBeNil)
+
+		r := httptest.NewRequest("GET", "https://example.com/", nil)
+		r.TLS.Version = tls.VersionTLS12
+		r.TLS.CipherSuite = tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+		r.TLS.ServerName = "example.com"
+		So(m.requestInfo(r, nil)["tls"], ShouldResemble, map[string]interface{}{
+			"version":      "TLS 1.2",
+			"cipher_suite": "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+			"server_name":  "example.com",
+		})
+	})
+}
+
+func TestUnwrapResponseWriter(t *testing.T) {
+	Convey("Wrapped response writers can be unwrapped", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		rr := httptest.NewRecorder()
+		var unwrapped http.ResponseWriter
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); ok {
+				unwrapped = u.Unwrap()
+			}
+		})
+		agent.NewHandler(handler, MiddlewareOptions{}).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		So(unwrapped, ShouldEqual, rr)
+	})
+}
+
+func TestQueueTime(t *testing.T) {
+	Convey("Queue time", t, func() {
+		start := time.Unix(1700000000, 500000000)
+
+		queued := func(header, value string) (time.Duration, bool) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set(header, value)
+			return queueTime(r, start)
+		}
+
+		Convey("parses seconds", func() {
+			wait, ok := queued("X-Request-Start", "t=1700000000.250")
+			So(ok, ShouldBeTrue)
+			So(wait, ShouldAlmostEqual, 250*time.Millisecond, float64(time.Millisecond))
+		})
+
+		Convey("parses milliseconds", func() {
+			wait, ok := queued("X-Queue-Start", "1700000000100")
+			So(ok, ShouldBeTrue)
+			So(wait, ShouldEqual, 400*time.Millisecond)
+		})
+
+		Convey("parses microseconds", func() {
+			wait, ok := queued("X-Request-Start", "t=1700000000499000")
+			So(ok, ShouldBeTrue)
+			So(wait, ShouldEqual, time.Millisecond)
+		})
+
+		Convey("ignores missing, invalid and future timestamps", func() {
+			_, ok := queueTime(httptest.NewRequest("GET", "/", nil), start)
+			So(ok, ShouldBeFalse)
+			_, ok = queued("X-Request-Start", "t=soon")
+			So(ok, ShouldBeFalse)
+			_, ok = queued("X-Request-Start", "t=1700000001")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
 func TestSetCallHeaders(t *testing.T) {
 	Convey("SetLogjamHeaders", t, func() {
 		agentOptions := Options{
@@ -369,6 +1145,41 @@ func TestSetCallHeaders(t *testing.T) {
 		SetCallHeaders(wrapped.Context(), outgoing)
 		So(outgoing.Header.Get("X-Logjam-Action"), ShouldEqual, "foobar")
 		So(outgoing.Header.Get("X-Logjam-Caller-Id"), ShouldEqual, logjamRequest.id)
+		So(outgoing.Header.Get("X-Logjam-Trace-Id"), ShouldEqual, logjamRequest.uuid)
+
+		Convey("uses the configured trace header", func() {
+			agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), TraceHeader: "X-Request-Id"})
+			logjamRequest := agent.NewRequest("foobar")
+			logjamRequest.SetTraceID("abc")
+			outgoing := httptest.NewRequest("GET", "/", nil)
+			SetCallHeaders(logjamRequest.NewContext(incoming.Context()), outgoing)
+			So(outgoing.Header.Get("X-Request-Id"), ShouldEqual, "abc")
+			So(outgoing.Header.Get("X-Logjam-Trace-Id"), ShouldEqual, "")
+		})
+	})
+}
+
+func TestNegroniHandler(t *testing.T) {
+	Convey("NegroniHandler", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		var finished *Request
+		var code int
+		h := agent.NewNegroniHandler(MiddlewareOptions{
+			BeforeFinish: func(r *http.Request, req *Request, c int) {
+				finished, code = req, c
+			},
+		})
+
+		r := httptest.NewRequest("GET", "/users/123", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) {
+			So(GetRequest(r.Context()), ShouldNotBeNil)
+			w.WriteHeader(http.StatusAccepted)
+		})
+		So(w.Code, ShouldEqual, http.StatusAccepted)
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Users::Id#get")
+		So(finished, ShouldNotBeNil)
+		So(code, ShouldEqual, http.StatusAccepted)
 	})
 }
 
@@ -0,0 +1,30 @@
+package mongo
+
+import (
+	"context"
+
+	"github.com/xing/logjam-agent-go"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// NewCommandMonitor returns a command monitor recording the time spent on MongoDB commands
+// as mongo_time and their number as mongo_calls on the logjam request of the operation
+// context. Failed commands are logged as ERROR lines. Install it using
+// options.Client().SetMonitor(mongo.NewCommandMonitor()).
+func NewCommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			if request := logjam.GetRequest(ctx); request != nil {
+				request.AddDuration("mongo_time", e.Duration)
+				request.Count("mongo_calls")
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			if request := logjam.GetRequest(ctx); request != nil {
+				request.AddDuration("mongo_time", e.Duration)
+				request.Count("mongo_calls")
+				request.Log(logjam.ERROR, "mongo "+e.CommandName+" failed: "+e.Failure)
+			}
+		},
+	}
+}
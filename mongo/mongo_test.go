@@ -0,0 +1,43 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+func TestCommandMonitor(t *testing.T) {
+	collector := logjamtest.NewCollector("mongo-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+	monitor := NewCommandMonitor()
+
+	Convey("recording commands on the logjam request", t, func() {
+		request := agent.NewRequestAt("Users#index", time.Now().Add(-time.Second))
+		ctx := request.NewContext(context.Background())
+
+		succeeded := &event.CommandSucceededEvent{}
+		succeeded.CommandName = "find"
+		succeeded.Duration = 2 * time.Millisecond
+		monitor.Succeeded(ctx, succeeded)
+		failed := &event.CommandFailedEvent{Failure: "connection refused"}
+		failed.CommandName = "insert"
+		failed.Duration = time.Millisecond
+		monitor.Failed(ctx, failed)
+		monitor.Succeeded(context.Background(), succeeded)
+		request.Finish(200)
+
+		output := collector.Receive()
+
+		So(output["mongo_time"], ShouldEqual, 3.0)
+		So(output["mongo_calls"], ShouldEqual, 2)
+		lines := output["lines"].([]interface{})
+		So(lines, ShouldHaveLength, 1)
+		So(lines[0].([]interface{})[2], ShouldEqual, "mongo insert failed: connection refused")
+	})
+}
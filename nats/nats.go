@@ -0,0 +1,48 @@
+package nats
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/xing/logjam-agent-go"
+)
+
+// Publisher is implemented by *nats.Conn.
+type Publisher interface {
+	PublishMsg(m *nats.Msg) error
+}
+
+var _ Publisher = (*nats.Conn)(nil)
+
+// Inject adds the logjam call headers of the logjam request found in the context to the
+// message headers, like logjam.SetCallHeaders does for HTTP requests, so that subscribers
+// can link their logjam requests to the publishing request.
+func Inject(ctx context.Context, msg *nats.Msg) {
+	headers := logjam.CallHeaders(ctx)
+	if headers == nil {
+		return
+	}
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	for name, values := range headers {
+		msg.Header[name] = values
+	}
+}
+
+// Publish injects the logjam call headers into the message and publishes it, recording the
+// time spent as nats_publish_time and the number of messages published as
+// nats_publish_calls on the logjam request found in the context.
+func Publish(ctx context.Context, publisher Publisher, msg *nats.Msg) error {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return publisher.PublishMsg(msg)
+	}
+	Inject(ctx, msg)
+	start := time.Now()
+	err := publisher.PublishMsg(msg)
+	request.AddDuration("nats_publish_time", time.Since(start))
+	request.Count("nats_publish_calls")
+	return err
+}
@@ -0,0 +1,52 @@
+package nats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+type recordingPublisher struct {
+	published []*nats.Msg
+}
+
+func (p *recordingPublisher) PublishMsg(m *nats.Msg) error {
+	p.published = append(p.published, m)
+	return nil
+}
+
+func TestPublish(t *testing.T) {
+	collector := logjamtest.NewCollector("nats-test", logjam.Options{
+		AppName: "appName",
+		EnvName: "envName",
+	})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("publishing messages with call headers", t, func() {
+		request := agent.NewRequest("Users#create")
+		ctx := request.NewContext(context.Background())
+		publisher := &recordingPublisher{}
+
+		So(Publish(ctx, publisher, &nats.Msg{Subject: "users.created", Data: []byte("42")}), ShouldBeNil)
+		header := publisher.published[0].Header
+		So(header.Get("X-Logjam-Action"), ShouldEqual, "Users#create")
+		So(header.Get("X-Logjam-Caller-Id"), ShouldStartWith, "appName-envName-")
+		So(header.Get("X-Logjam-Trace-Id"), ShouldEqual, request.TraceID())
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output, ShouldContainKey, "nats_publish_time")
+		So(output["nats_publish_calls"], ShouldEqual, 1)
+	})
+
+	Convey("publishing messages without logjam request", t, func() {
+		publisher := &recordingPublisher{}
+		So(Publish(context.Background(), publisher, &nats.Msg{Subject: "users.created"}), ShouldBeNil)
+		So(publisher.published[0].Header, ShouldBeNil)
+	})
+}
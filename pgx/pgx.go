@@ -0,0 +1,89 @@
+package pgx
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/sanitize"
+)
+
+type contextKey int
+
+// traceKey stores the trace of a query or batch in the context.
+const traceKey contextKey = 0
+
+// trace holds what TraceQueryEnd and TraceBatchEnd need to know about the query or batch.
+type trace struct {
+	start time.Time // when the query or batch started
+	sql   string    // the SQL of the query, empty for batches
+}
+
+// Tracer implements pgx.QueryTracer and pgx.BatchTracer, recording the time spent on
+// queries as db_time and their number as db_calls on the logjam request of the query
+// context. Failed queries are logged as ERROR lines, with literals removed from the SQL.
+// Batches are timed as a whole, while each query of a batch counts as a call. Install it
+// by setting the Tracer field of the pgx.ConnConfig, e.g.
+// config.ConnConfig.Tracer = &pgx.Tracer{}.
+type Tracer struct{}
+
+var (
+	_ pgx.QueryTracer = (*Tracer)(nil)
+	_ pgx.BatchTracer = (*Tracer)(nil)
+)
+
+// TraceQueryStart implements pgx.QueryTracer.
+func (t *Tracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if logjam.GetRequest(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, traceKey, &trace{start: time.Now(), sql: data.SQL})
+}
+
+// TraceQueryEnd implements pgx.QueryTracer.
+func (t *Tracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	request := logjam.GetRequest(ctx)
+	tr, ok := ctx.Value(traceKey).(*trace)
+	if request == nil || !ok {
+		return
+	}
+	request.AddDuration("db_time", time.Since(tr.start))
+	request.Count("db_calls")
+	if data.Err != nil {
+		request.Log(logjam.ERROR, "pgx query failed: "+data.Err.Error()+": "+sanitize.SQL(tr.sql))
+	}
+}
+
+// TraceBatchStart implements pgx.BatchTracer.
+func (t *Tracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	if logjam.GetRequest(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, traceKey, &trace{start: time.Now()})
+}
+
+// TraceBatchQuery implements pgx.BatchTracer.
+func (t *Tracer) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return
+	}
+	request.Count("db_calls")
+	if data.Err != nil {
+		request.Log(logjam.ERROR, "pgx batch query failed: "+data.Err.Error()+": "+sanitize.SQL(data.SQL))
+	}
+}
+
+// TraceBatchEnd implements pgx.BatchTracer.
+func (t *Tracer) TraceBatchEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchEndData) {
+	request := logjam.GetRequest(ctx)
+	tr, ok := ctx.Value(traceKey).(*trace)
+	if request == nil || !ok {
+		return
+	}
+	request.AddDuration("db_time", time.Since(tr.start))
+	if data.Err != nil {
+		request.Log(logjam.ERROR, "pgx batch failed: "+data.Err.Error())
+	}
+}
@@ -0,0 +1,44 @@
+package pgx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestTracer(t *testing.T) {
+	collector := logjamtest.NewCollector("pgx-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+	tracer := &Tracer{}
+
+	Convey("recording queries and batches on the logjam request", t, func() {
+		request := agent.NewRequest("Users#index")
+		ctx := request.NewContext(context.Background())
+
+		qctx := tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT * FROM users"})
+		tracer.TraceQueryEnd(qctx, nil, pgx.TraceQueryEndData{})
+		qctx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT * FROM nowhere WHERE name = 'Alice'"})
+		tracer.TraceQueryEnd(qctx, nil, pgx.TraceQueryEndData{Err: errors.New("relation does not exist")})
+
+		bctx := tracer.TraceBatchStart(ctx, nil, pgx.TraceBatchStartData{})
+		tracer.TraceBatchQuery(bctx, nil, pgx.TraceBatchQueryData{SQL: "UPDATE users SET name = $1"})
+		tracer.TraceBatchQuery(bctx, nil, pgx.TraceBatchQueryData{SQL: "DELETE FROM users WHERE id = 42", Err: errors.New("permission denied")})
+		tracer.TraceBatchEnd(bctx, nil, pgx.TraceBatchEndData{})
+		request.Finish(200)
+
+		output := collector.Receive()
+
+		So(output, ShouldContainKey, "db_time")
+		So(output["db_calls"], ShouldEqual, 4)
+		lines := output["lines"].([]interface{})
+		So(lines, ShouldHaveLength, 2)
+		So(lines[0].([]interface{})[2], ShouldEqual, "pgx query failed: relation does not exist: SELECT * FROM nowhere WHERE name = ?")
+		So(lines[1].([]interface{})[2], ShouldEqual, "pgx batch query failed: permission denied: DELETE FROM users WHERE id = ?")
+	})
+}
@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/xing/logjam-agent-go"
+)
+
+// getCommands are the commands counted as cache hits or misses.
+var getCommands = map[string]bool{
+	"get": true, "getex": true, "getdel": true, "hget": true, "mget": true, "hmget": true,
+}
+
+// Hook is a go-redis hook recording the time spent on redis commands as redis_time and
+// their number as redis_calls on the logjam request of the command context. Get-type
+// commands count cache hits as redis_hits and misses as redis_misses, with one hit or miss
+// per key for multi key commands. Pipelines are timed as a whole, while each command of a
+// pipeline counts as a call. Install it using client.AddHook(redis.Hook{}).
+type Hook struct{}
+
+var _ redis.Hook = Hook{}
+
+// DialHook implements redis.Hook.
+func (Hook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+// ProcessHook implements redis.Hook.
+func (Hook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		request := logjam.GetRequest(ctx)
+		if request == nil {
+			return next(ctx, cmd)
+		}
+		start := time.Now()
+		err := next(ctx, cmd)
+		request.AddDuration("redis_time", time.Since(start))
+		record(request, cmd)
+		return err
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook.
+func (Hook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		request := logjam.GetRequest(ctx)
+		if request == nil {
+			return next(ctx, cmds)
+		}
+		start := time.Now()
+		err := next(ctx, cmds)
+		request.AddDuration("redis_time", time.Since(start))
+		for _, cmd := range cmds {
+			record(request, cmd)
+		}
+		return err
+	}
+}
+
+// record counts a command and, for get-type commands, its cache hits and misses.
+func record(request *logjam.Request, cmd redis.Cmder) {
+	request.Count("redis_calls")
+	if !getCommands[cmd.Name()] {
+		return
+	}
+	if sc, ok := cmd.(*redis.SliceCmd); ok && sc.Err() == nil {
+		for _, v := range sc.Val() {
+			if v == nil {
+				request.Count("redis_misses")
+			} else {
+				request.Count("redis_hits")
+			}
+		}
+		return
+	}
+	switch cmd.Err() {
+	case nil:
+		request.Count("redis_hits")
+	case redis.Nil:
+		request.Count("redis_misses")
+	}
+}
@@ -0,0 +1,46 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestHook(t *testing.T) {
+	collector := logjamtest.NewCollector("redis-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("recording commands and cache hits on the logjam request", t, func() {
+		request := agent.NewRequest("Users#show")
+		ctx := request.NewContext(context.Background())
+
+		process := Hook{}.ProcessHook(func(ctx context.Context, cmd redis.Cmder) error {
+			if cmd.Args()[1] == "missing" {
+				cmd.SetErr(redis.Nil)
+			}
+			return cmd.Err()
+		})
+		So(process(ctx, redis.NewStringCmd(ctx, "get", "present")), ShouldBeNil)
+		So(process(ctx, redis.NewStringCmd(ctx, "get", "missing")), ShouldEqual, redis.Nil)
+		So(process(ctx, redis.NewStringCmd(ctx, "set", "present", "1")), ShouldBeNil)
+
+		pipeline := Hook{}.ProcessPipelineHook(func(ctx context.Context, cmds []redis.Cmder) error {
+			cmds[0].(*redis.SliceCmd).SetVal([]interface{}{"1", nil, "3"})
+			return nil
+		})
+		So(pipeline(ctx, []redis.Cmder{redis.NewSliceCmd(ctx, "mget", "a", "b", "c")}), ShouldBeNil)
+		request.Finish(200)
+
+		output := collector.Receive()
+
+		So(output, ShouldContainKey, "redis_time")
+		So(output["redis_calls"], ShouldEqual, 4)
+		So(output["redis_hits"], ShouldEqual, 3)
+		So(output["redis_misses"], ShouldEqual, 2)
+	})
+}
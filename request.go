@@ -5,12 +5,17 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
-	"log"
+	mathrand "math/rand"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang/snappy"
 )
@@ -23,44 +28,141 @@ type Request struct {
 	id                 string                   // Request id as sent to called applications (app-env-uuid).
 	callerID           string                   // Request id of the caller (if any).
 	callerAction       string                   // Action name of the caller (if any).
+	stream             string                   // The stream name to send the request to, overriding the agent's stream (if set).
+	topic              string                   // The log topic to send the request to, overriding the agent's topic (if set).
+	routeTemplate      string                   // Template of the matched route, set by router integrations (if any).
 	traceID            string                   // Trace id for this request.
+	traceFlags         string                   // W3C trace flags of the incoming traceparent header (if any).
+	traceState         string                   // W3C tracestate header of the incoming request (if any).
+	b3                 B3Format                 // B3 header formats to send to called applications.
+	b3Sampled          string                   // B3 sampling state of the incoming request (if any).
 	startTime          time.Time                // Start time of this request.
 	endTime            time.Time                // Completion time of this request.
+	streamStart        time.Time                // When streaming the response started (if the request was streamed).
+	allocStart         allocations              // Heap allocation counters at the start of this request.
+	allocEnd           allocations              // Heap allocation counters at completion of this request.
 	durations          map[string]time.Duration // Time metrics.
 	counts             map[string]int64         // Counters.
-	logLines           []interface{}            // Log lines.
+	bytes              map[string]int64         // Byte size metrics.
+	logLines           []logLine                // Log lines, formatted when the request is finished.
 	logLinesBytesCount int                      // Byte size of logged lines.
 	severity           LogLevel                 // Max log severity over all log lines.
 	fields             map[string]interface{}   // Additional kye vale pairs for JSON payload sent to logjam.
 	info               map[string]interface{}   // Information about the associated HTTP request.
 	ip                 string                   // IP of the HTTP request originator.
-	exceptions         map[string]bool          // List of exception tags to send to logjam.
+	exceptions         map[string]int           // Exception tags to send to logjam and how often they occurred.
+	ignored            bool                     // Whether the request should not be sent to logjam.
+	sampled            bool                     // Whether the request was selected by the sampler.
+	sampleRate         float64                  // Sample rate overriding the sample rates of the middleware (if set).
+	active             bool                     // Whether the request is counted as in flight by the agent.
+	unpooled           bool                     // Whether the request must not be reused after Finish, as other goroutines may still access it.
+	load               int64                    // Number of requests in flight when the request started, including itself.
 	mutex              sync.Mutex               // Mutex for protecting mutators
 }
 
 // NewRequest creates a new logjam request for a given action name.
 func (a *Agent) NewRequest(action string) *Request {
-	r := Request{
-		agent:      a,
-		action:     action,
+	return a.NewRequestAt(action, time.Now())
+}
+
+// NewRequestAt creates a new logjam request for a given action name which conceptually
+// started at the given time, for example when a message was enqueued or accepted by an
+// upstream proxy. The total time reported to logjam is measured from this point.
+func (a *Agent) NewRequestAt(action string, start time.Time) *Request {
+	return a.newRequest(action, start, nil)
+}
+
+// NewScratchRequest creates a request for collecting metrics of work fanned out in
+// parallel, to be folded into the main request using Merge. Scratch requests are not
+// counted as in flight, are never sent to logjam and don't need to be finished.
+func (a *Agent) NewScratchRequest(action string) *Request {
+	r := allocateRequest()
+	r.agent = a
+	r.action = action
+	r.startTime = time.Now()
+	r.sampled = true
+	return r
+}
+
+func (a *Agent) newRequest(action string, start time.Time, incoming *http.Request) *Request {
+	var r *Request
+	if a.PoolRequests {
+		r = requestPool.Get().(*Request)
+	} else {
+		r = allocateRequest()
+	}
+	r.agent = a
+	r.action = action
+	r.startTime = start
+	r.sampled = a.Sampler == nil || a.Sampler(action, incoming)
+	if a.MeasureAllocations {
+		r.allocStart = readAllocations()
+	}
+	r.active = true
+	r.load = atomic.AddInt64(&a.inFlight, 1)
+	r.uuid = a.generateID()
+	r.traceID = r.uuid
+	r.id = a.AppName + "-" + a.EnvName + "-" + r.uuid
+	return r
+}
+
+// requestPool holds finished requests for reuse if the agent option PoolRequests is set.
+var requestPool = sync.Pool{
+	New: func() interface{} {
+		return allocateRequest()
+	},
+}
+
+func allocateRequest() *Request {
+	return &Request{
 		durations:  map[string]time.Duration{},
 		counts:     map[string]int64{},
+		bytes:      map[string]int64{},
 		fields:     map[string]interface{}{},
-		logLines:   []interface{}{},
-		exceptions: map[string]bool{},
+		logLines:   []logLine{},
+		exceptions: map[string]int{},
 		severity:   INFO,
 	}
-	r.startTime = time.Now()
-	r.uuid = generateUUID()
-	r.traceID = r.uuid
-	r.id = a.AppName + "-" + a.EnvName + "-" + r.uuid
-	return &r
+}
+
+// release resets the request and puts it back into the request pool. The maps and the
+// log lines slice are kept to avoid allocating them again.
+func (r *Request) release() {
+	for key := range r.durations {
+		delete(r.durations, key)
+	}
+	for key := range r.counts {
+		delete(r.counts, key)
+	}
+	for key := range r.bytes {
+		delete(r.bytes, key)
+	}
+	for key := range r.fields {
+		delete(r.fields, key)
+	}
+	for key := range r.exceptions {
+		delete(r.exceptions, key)
+	}
+	for i := range r.logLines {
+		r.logLines[i] = logLine{}
+	}
+	*r = Request{
+		durations:  r.durations,
+		counts:     r.counts,
+		bytes:      r.bytes,
+		fields:     r.fields,
+		logLines:   r.logLines[:0],
+		exceptions: r.exceptions,
+		severity:   INFO,
+	}
+	requestPool.Put(r)
 }
 
 type contextKey int
 
 const (
 	requestKey contextKey = 0
+	loggerKey  contextKey = 1
 )
 
 // NewContext creates a new context with the request added.
@@ -73,6 +175,38 @@ func (r *Request) AugmentRequest(incoming *http.Request) *http.Request {
 	return incoming.WithContext(r.NewContext(incoming.Context()))
 }
 
+// SetStartTime overrides the start time of the request. Use it when the request has been
+// created before the actual start time of the work was known.
+func (r *Request) SetStartTime(t time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.startTime = t
+}
+
+// SetCaller sets the request id and action name of the caller. The middleware takes them
+// from the X-Logjam-Caller-Id and X-Logjam-Action headers. Use this for requests which
+// aren't created by the middleware, like message consumers or background jobs.
+func (r *Request) SetCaller(callerID, callerAction string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.callerID = callerID
+	r.callerAction = callerAction
+}
+
+// SetTraceID sets the trace id of the request. It defaults to the request uuid.
+func (r *Request) SetTraceID(traceID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.traceID = traceID
+}
+
+// TraceID returns the trace id of the request.
+func (r *Request) TraceID() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.traceID
+}
+
 // ChangeAction changes the action name and updates the corresponding header on the given
 // http request writer.
 func (r *Request) ChangeAction(w http.ResponseWriter, action string) {
@@ -80,6 +214,33 @@ func (r *Request) ChangeAction(w http.ResponseWriter, action string) {
 	w.Header().Set("X-Logjam-Action", action)
 }
 
+// SetStream makes the request go to the logjam stream of the given application and
+// environment instead of the agent's stream, e.g. for binaries serving several tenants.
+// Empty arguments default to the application and environment names of the agent. The
+// request id sent to called applications is adjusted accordingly.
+func (r *Request) SetStream(appName, envName string) {
+	if appName == "" {
+		appName = r.agent.AppName
+	}
+	if envName == "" {
+		envName = r.agent.EnvName
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stream = appName + "-" + envName
+	r.topic = "logs." + appName + "." + envName
+	r.id = appName + "-" + envName + "-" + r.uuid
+}
+
+// SetRouteTemplate records the template of the route matched by the router, e.g.
+// /users/{id}. The middleware derives the action name from it if the action name
+// extractor couldn't come up with one (i.e. returned Unknown#<method>).
+func (r *Request) SetRouteTemplate(template string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.routeTemplate = template
+}
+
 // GetRequest retrieves a logjam request from an Context. Returns nil if no
 // request is stored in the context.
 func GetRequest(ctx context.Context) *Request {
@@ -90,6 +251,27 @@ func GetRequest(ctx context.Context) *Request {
 	return nil
 }
 
+// ChangeAction changes the action name of the logjam request stored in the given context
+// and updates the X-Logjam-Action response header. Does nothing if the context holds no
+// logjam request.
+func ChangeAction(ctx context.Context, w http.ResponseWriter, action string) {
+	if r := GetRequest(ctx); r != nil {
+		r.ChangeAction(w, action)
+	}
+}
+
+// SetAction changes the action name of the logjam request stored in the given context,
+// without updating response headers. Use it where no response writer is available, or
+// the response has already been written. Does nothing if the context holds no logjam
+// request.
+func SetAction(ctx context.Context, action string) {
+	if r := GetRequest(ctx); r != nil {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.action = action
+	}
+}
+
 // Log adds a log line to be sent to logjam to the request.
 func (r *Request) Log(severity LogLevel, line string) {
 	r.mutex.Lock()
@@ -112,14 +294,16 @@ func (r *Request) Log(severity LogLevel, line string) {
 	lineLen := len(line)
 	r.logLinesBytesCount += lineLen
 	if r.logLinesBytesCount < r.agent.MaxBytesAllLines {
-		r.logLines = append(r.logLines, formatLine(severity, time.Now(), line, r.agent.MaxLineLength))
+		r.logLines = append(r.logLines, logLine{severity: severity, timeStamp: time.Now(), message: line})
 	} else {
-		r.logLines = append(r.logLines, formatLine(severity, time.Now(), linesTruncated, r.agent.MaxLineLength))
+		r.logLines = append(r.logLines, logLine{severity: severity, timeStamp: time.Now(), message: linesTruncated})
 	}
 }
 
-// SetField sets an additional key value pair on the request.
+// SetField sets an additional key value pair on the request. Values exceeding the
+// MaxFieldBytes option of the agent are replaced by their truncated JSON representation.
 func (r *Request) SetField(key string, value interface{}) {
+	value = truncateField(value, r.agent.MaxFieldBytes)
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	r.fields[key] = value
@@ -132,11 +316,39 @@ func (r *Request) GetField(key string) interface{} {
 	return r.fields[key]
 }
 
-// AddException adds an exception tag to be sent to logjam.
+// AddException adds an exception tag to be sent to logjam. Unless the tag matches one of
+// the soft exceptions configured on the agent, the severity of the request is raised to
+// ERROR.
 func (r *Request) AddException(name string) {
+	r.AddExceptionCount(name, 1)
+}
+
+// AddExceptionCount adds an exception tag to be sent to logjam and increments the number
+// of times the exception occurred during the request by n. Use this for recoverable
+// errors which happen repeatedly, like failed retries of upstream calls.
+func (r *Request) AddExceptionCount(name string, n int) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	r.exceptions[name] = true
+	r.exceptions[name] += n
+	if r.severity < ERROR && !r.agent.isSoftException(name) {
+		r.severity = ERROR
+	}
+}
+
+// raiseSeverity raises the severity of the request to the given level, if higher.
+func (r *Request) raiseSeverity(severity LogLevel) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.severity < severity {
+		r.severity = severity
+	}
+}
+
+// addSoftException adds an exception tag without raising the severity of the request.
+func (r *Request) addSoftException(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.exceptions[name]++
 }
 
 // AddCount increments a counter metric associated with this request.
@@ -151,6 +363,22 @@ func (r *Request) Count(key string) {
 	r.AddCount(key, 1)
 }
 
+// Conventional keys for byte size metrics recorded with AddBytes.
+const (
+	ResponseBytes = "response_bytes" // Size of response bodies sent to the client.
+	RestBytes     = "rest_bytes"     // Size of responses received from other services.
+	DBBytes       = "db_bytes"       // Size of results received from databases.
+	BlobBytes     = "blob_bytes"     // Size of objects uploaded to or downloaded from blob stores.
+)
+
+// AddBytes increments a byte size metric associated with this request. Byte sizes are
+// kept apart from counters, so that they can't be confused with call counts.
+func (r *Request) AddBytes(key string, n int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.bytes[key] += n
+}
+
 // AddDuration increases increments a timer metric associated with this request.
 func (r *Request) AddDuration(key string, value time.Duration) {
 	r.mutex.Lock()
@@ -162,6 +390,54 @@ func (r *Request) AddDuration(key string, value time.Duration) {
 	}
 }
 
+// Merge adds the durations, counters, byte sizes and exceptions of the other request to
+// the request and raises its severity to the severity of the other request, if higher.
+// This allows fanning out work to scratch requests in parallel and folding the results
+// into the main request afterwards. The other request is left unchanged. Create it with
+// NewScratchRequest, so that it isn't counted as in flight.
+func (r *Request) Merge(other *Request) {
+	if other == r {
+		return
+	}
+	other.mutex.Lock()
+	durations := make(map[string]time.Duration, len(other.durations))
+	for key, value := range other.durations {
+		durations[key] = value
+	}
+	counts := make(map[string]int64, len(other.counts))
+	for key, value := range other.counts {
+		counts[key] = value
+	}
+	bytes := make(map[string]int64, len(other.bytes))
+	for key, value := range other.bytes {
+		bytes[key] = value
+	}
+	exceptions := make(map[string]int, len(other.exceptions))
+	for name, n := range other.exceptions {
+		exceptions[name] = n
+	}
+	severity := other.severity
+	other.mutex.Unlock()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for key, value := range durations {
+		r.durations[key] += value
+	}
+	for key, value := range counts {
+		r.counts[key] += value
+	}
+	for key, value := range bytes {
+		r.bytes[key] += value
+	}
+	for name, n := range exceptions {
+		r.exceptions[name] += n
+	}
+	if r.severity < severity {
+		r.severity = severity
+	}
+}
+
 // MeasureDuration is a helper function that records the duration of execution of the
 // passed function in cases where it is cumbersome to just use AddDuration instead.
 func (r *Request) MeasureDuration(key string, f func()) {
@@ -170,28 +446,153 @@ func (r *Request) MeasureDuration(key string, f func()) {
 	f()
 }
 
-// Finish adds the response code to the requests and sends it to logjam.
+// Ignore marks the request as not to be sent to logjam. Finish becomes a no-op for ignored
+// requests. Use this for requests you don't want to monitor, like health checks.
+func (r *Request) Ignore() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.ignored = true
+}
+
+// Ignored returns whether the request has been marked as ignored.
+func (r *Request) Ignored() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ignored
+}
+
+// SetSampleRate overrides the fraction of successful requests sent to logjam, which the
+// middleware otherwise takes from its SampleRate and ActionSampleRates options. Rates
+// outside of (0, 1] are ignored.
+func (r *Request) SetSampleRate(rate float64) {
+	if rate <= 0 || rate > 1 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sampleRate = rate
+}
+
+// Sampled returns whether the request has been selected by the sampler configured on the
+// agent.
+func (r *Request) Sampled() bool {
+	return r.sampled
+}
+
+// Finish adds the response code to the requests and sends it to logjam, unless the
+// request has been ignored or has not been sampled and completed without errors. If the
+// agent option PoolRequests is set, the request is reused afterwards and must not be
+// accessed anymore, unless its connection was hijacked. Calling Finish more than once has
+// no effect.
 func (r *Request) Finish(code int) {
+	r.mutex.Lock()
+	finishing := r.active
+	r.active = false
+	unpooled := r.unpooled
+	r.mutex.Unlock()
+	if !finishing {
+		return
+	}
+	atomic.AddInt64(&r.agent.inFlight, -1)
+	if r.agent.PoolRequests && !unpooled {
+		defer r.release()
+	}
+	if r.Ignored() || !(r.Sampled() || r.failed(code)) {
+		return
+	}
 	r.endTime = time.Now()
+	if r.agent.MeasureAllocations {
+		r.allocEnd = readAllocations()
+	}
+	for key, value := range r.agent.takeAttached() {
+		r.AddCount(key, value)
+	}
+	r.annotateSlowRequest()
 
 	payload := r.logjamPayload(code)
 
-	buf, err := json.Marshal(&payload)
+	buf, err := encodePayload(payload)
 	if err != nil {
 		r.agent.Logger.Println(err)
 		return
 	}
 	data := snappy.Encode(nil, buf)
-	r.agent.sendMessage(data)
+	stream, topic := r.agent.stream, r.agent.topic
+	if r.stream != "" {
+		stream, topic = r.stream, r.topic
+	}
+	r.agent.sendMessage(stream, topic, data)
 }
 
+// slowRequestException is the exception tag added to requests exceeding the agent option
+// SlowRequestThreshold. It never raises the severity of the request to ERROR.
+const slowRequestException = "slow_request"
+
+// annotateSlowRequest adds a WARN line with the duration breakdown and the slow request
+// exception tag if the request took longer than the configured threshold.
+func (r *Request) annotateSlowRequest() {
+	threshold := r.agent.SlowRequestThreshold
+	total := r.duration()
+	if threshold <= 0 || total <= threshold {
+		return
+	}
+	r.mutex.Lock()
+	keys := make([]string, 0, len(r.durations))
+	for key := range r.durations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	breakdown := make([]string, 0, len(keys))
+	for _, key := range keys {
+		breakdown = append(breakdown, fmt.Sprintf("%s=%s", key, r.durations[key]))
+	}
+	r.exceptions[slowRequestException]++
+	r.mutex.Unlock()
+	line := fmt.Sprintf("slow request: total_time=%s exceeds %s", total, threshold)
+	if len(breakdown) > 0 {
+		line += " (" + strings.Join(breakdown, ", ") + ")"
+	}
+	r.Log(WARN, line)
+}
+
+// encodePayload serializes the payload to JSON. If this fails, values which can't be
+// serialized are replaced by their string representation and the errors are reported in
+// the "encoding_errors" field, so that the rest of the payload still reaches logjam.
+func encodePayload(payload map[string]interface{}) ([]byte, error) {
+	buf, err := json.Marshal(payload)
+	if err == nil {
+		return buf, nil
+	}
+	encodingErrors := []string{}
+	for key, val := range payload {
+		if _, err := json.Marshal(val); err != nil {
+			encodingErrors = append(encodingErrors, key+": "+err.Error())
+			payload[key] = fmt.Sprintf("%v", val)
+		}
+	}
+	sort.Strings(encodingErrors)
+	payload["encoding_errors"] = encodingErrors
+	return json.Marshal(payload)
+}
+
+// failed returns whether the request ended with an error. Hard exceptions are covered by
+// the severity check.
+func (r *Request) failed(code int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return code >= 500 || r.severity >= ERROR
+}
+
+// durationCorrectionFactor returns the factor by which time metrics need to be scaled so
+// that their sum stays below the total time of the request, which can happen when time
+// metrics were measured in parallel.
 func (r *Request) durationCorrectionFactor(totalTime float64) float64 {
 	s := float64(0)
 	for _, d := range r.durations {
-		s += float64(d / time.Millisecond)
+		s += milliseconds(d)
 	}
 	if s > totalTime {
-		return (totalTime - 0.1) / s
+		return (totalTime - 0.001) / s
 	}
 	return 1.0
 }
@@ -208,9 +609,17 @@ func (r *Request) logjamPayload(code int) map[string]interface{} {
 		"started_at": r.startTime.Format(timeFormat),
 		"started_ms": r.startTime.UnixNano() / 1000000,
 		"total_time": totalTime,
+		"load":       r.load,
+	}
+	if r.streaming() {
+		msg["streaming_duration"] = milliseconds(r.endTime.Sub(r.streamStart))
 	}
 	if len(r.logLines) > 0 {
-		msg["lines"] = r.logLines
+		lines := make([]interface{}, len(r.logLines))
+		for i, l := range r.logLines {
+			lines[i] = formatLine(l.severity, l.timeStamp, l.message, r.agent.MaxLineLength)
+		}
+		msg["lines"] = lines
 	}
 	if len(r.info) > 0 {
 		msg["request_info"] = r.info
@@ -230,17 +639,35 @@ func (r *Request) logjamPayload(code int) map[string]interface{} {
 			exceptions = append(exceptions, name)
 		}
 		msg["exceptions"] = exceptions
+		msg["exception_counts"] = r.exceptions
+	}
+	if r.agent.MeasureAllocations {
+		allocated := r.allocEnd.since(r.allocStart)
+		msg["allocated_memory"] = allocated.bytes
+		msg["allocated_objects"] = allocated.objects
 	}
 	for key, val := range requestEnv {
 		msg[key] = val
 	}
 	c := r.durationCorrectionFactor(totalTime)
 	for key, duration := range r.durations {
-		msg[key] = c * float64(duration/time.Millisecond)
+		msg[key] = c * milliseconds(duration)
+	}
+	if r.agent.SquaredDurations {
+		// logjam sums the squares over all requests, so they are taken of the totals of
+		// the request, not of the individual durations added.
+		msg["total_time_sq"] = totalTime * totalTime
+		for key, duration := range r.durations {
+			ms := c * milliseconds(duration)
+			msg[key+"_sq"] = ms * ms
+		}
 	}
 	for key, count := range r.counts {
 		msg[key] = count
 	}
+	for key, n := range r.bytes {
+		msg[key] = n
+	}
 	for key, val := range r.fields {
 		msg[key] = val
 	}
@@ -248,7 +675,25 @@ func (r *Request) logjamPayload(code int) map[string]interface{} {
 }
 
 func (r *Request) totalTime() float64 {
-	return float64(r.endTime.Sub(r.startTime)) / float64(time.Millisecond)
+	return milliseconds(r.duration())
+}
+
+// duration returns the time it took to process the request. For streamed requests, the
+// time spent streaming the response is excluded.
+func (r *Request) duration() time.Duration {
+	if r.streaming() {
+		return r.streamStart.Sub(r.startTime)
+	}
+	return r.endTime.Sub(r.startTime)
+}
+
+func (r *Request) streaming() bool {
+	return !r.streamStart.IsZero() && r.streamStart.Before(r.endTime)
+}
+
+// milliseconds converts a duration to milliseconds with microsecond precision.
+func milliseconds(d time.Duration) float64 {
+	return float64(d/time.Microsecond) / 1000
 }
 
 var requestEnv = make(map[string]string, 0)
@@ -271,12 +716,49 @@ func setRequestEnv() {
 	}
 }
 
+// generateID returns a new request id, using the IDGenerator of the agent if set. If the
+// default UUID generator can't read from crypto/rand, it falls back to math/rand, counts
+// the failure and logs it.
+func (a *Agent) generateID() string {
+	if a.IDGenerator != nil {
+		return a.IDGenerator()
+	}
+	uuid, err := generateUUID()
+	if err != nil {
+		atomic.AddUint64(&a.idFallbacks, 1)
+		a.Logger.Println("logjam: falling back to math/rand for request id generation:", err)
+	}
+	return uuid
+}
+
+// InFlight returns the number of requests created by the agent which haven't been finished
+// yet.
+func (a *Agent) InFlight() int64 {
+	return atomic.LoadInt64(&a.inFlight)
+}
+
+// IDFallbacks returns the number of request ids generated using math/rand because reading
+// from crypto/rand failed.
+func (a *Agent) IDFallbacks() uint64 {
+	return atomic.LoadUint64(&a.idFallbacks)
+}
+
+// fallbackRandom is a math/rand generator used when crypto/rand fails.
+var fallbackRandom = struct {
+	sync.Mutex
+	*mathrand.Rand
+}{Rand: mathrand.New(mathrand.NewSource(time.Now().UnixNano()))}
+
 // generateUUID provides a Logjam compatible UUID, which means it doesn't adhere to the
-// standard by having the dashes removed.
-func generateUUID() string {
+// standard by having the dashes removed. If reading from crypto/rand fails, the UUID is
+// generated using math/rand and the error is returned alongside.
+func generateUUID() (string, error) {
 	uuid := make([]byte, 16)
-	if _, err := io.ReadFull(rand.Reader, uuid); err != nil {
-		log.Fatalln(err)
+	_, err := io.ReadFull(rand.Reader, uuid)
+	if err != nil {
+		fallbackRandom.Lock()
+		fallbackRandom.Read(uuid)
+		fallbackRandom.Unlock()
 	}
 	uuid[6] = (uuid[6] & 0x0f) | 0x40 // Version 4
 	uuid[8] = (uuid[8] & 0x3f) | 0x80 // Variant is 10
@@ -284,12 +766,21 @@ func generateUUID() string {
 	var hexbuf [32]byte
 
 	hex.Encode(hexbuf[:], uuid[:])
-	return string(hexbuf[:])
+	return string(hexbuf[:]), err
+}
+
+// logLine holds the raw data of a log line. Formatting and truncation of log lines is
+// deferred until the request is finished.
+type logLine struct {
+	severity  LogLevel
+	timeStamp time.Time
+	message   string
 }
 
 const timeFormat = "2006-01-02T15:04:05.000000"
 const lineTruncated = " ... [LINE TRUNCATED]"
 const linesTruncated = "... [LINES DROPPED]"
+const fieldTruncated = " ... [FIELD TRUNCATED]"
 
 func formatLine(severity LogLevel, timeStamp time.Time, message string, maxLineLength int) []interface{} {
 	if len(message) > maxLineLength {
@@ -298,6 +789,35 @@ func formatLine(severity LogLevel, timeStamp time.Time, message string, maxLineL
 	return []interface{}{int(severity), formatTime(timeStamp), message}
 }
 
+func truncateField(value interface{}, maxBytes int) interface{} {
+	var s string
+	switch v := value.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return value
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return value
+		}
+		s = string(buf)
+	}
+	if len(s) <= maxBytes {
+		return value
+	}
+	if maxBytes <= len(fieldTruncated) {
+		return fieldTruncated
+	}
+	n := maxBytes - len(fieldTruncated)
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[0:n] + fieldTruncated
+}
+
 func formatTime(timeStamp time.Time) string {
 	return timeStamp.Format(timeFormat)
 }
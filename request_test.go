@@ -1,12 +1,19 @@
 package logjam
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -89,7 +96,507 @@ func TestLog(t *testing.T) {
 				r.Log(DEBUG, strings.Repeat("x", maxLineLength))
 			}
 			So(r.logLines, ShouldHaveLength, overflow+1)
-			So(r.logLines[overflow].([]interface{})[2], ShouldEqual, linesTruncated)
+			So(r.logLines[overflow].message, ShouldEqual, linesTruncated)
 		})
 	})
 }
+
+func TestStartTime(t *testing.T) {
+	Convey("Start time", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		start := time.Now().Add(-time.Second)
+
+		Convey("can be given on creation", func() {
+			r := agent.NewRequestAt("foo", start)
+			r.endTime = start.Add(1500 * time.Millisecond)
+			So(r.startTime, ShouldEqual, start)
+			So(r.totalTime(), ShouldAlmostEqual, 1500, 0.001)
+		})
+
+		Convey("can be overridden", func() {
+			r := agent.NewRequest("foo")
+			r.SetStartTime(start)
+			So(r.startTime, ShouldEqual, start)
+		})
+	})
+}
+
+func TestSquaredDurations(t *testing.T) {
+	Convey("Squared durations", t, func() {
+		start := time.Now()
+
+		Convey("are sent when enabled", func() {
+			agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), SquaredDurations: true})
+			r := agent.NewRequestAt("foo", start)
+			r.AddDuration("db_time", 3*time.Millisecond)
+			r.AddDuration("db_time", 4*time.Millisecond)
+			r.endTime = start.Add(time.Second)
+			payload := r.logjamPayload(200)
+			So(payload["db_time"], ShouldEqual, 7)
+			So(payload["db_time_sq"], ShouldEqual, 49)
+			So(payload["total_time_sq"], ShouldEqual, 1000000)
+		})
+
+		Convey("are omitted by default", func() {
+			agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+			r := agent.NewRequestAt("foo", start)
+			r.AddDuration("db_time", 3*time.Millisecond)
+			r.endTime = start.Add(time.Second)
+			payload := r.logjamPayload(200)
+			So(payload, ShouldNotContainKey, "db_time_sq")
+			So(payload, ShouldNotContainKey, "total_time_sq")
+		})
+	})
+}
+
+var allocationSink []byte
+
+func TestMeasureAllocations(t *testing.T) {
+	Convey("Measuring allocations", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), MeasureAllocations: true})
+		r := agent.NewRequest("foo")
+		allocationSink = make([]byte, 1024*1024)
+		r.endTime = time.Now()
+		r.allocEnd = readAllocations()
+		payload := r.logjamPayload(200)
+		So(payload["allocated_memory"], ShouldBeGreaterThanOrEqualTo, 1024*1024)
+		So(payload["allocated_objects"], ShouldBeGreaterThanOrEqualTo, 1)
+	})
+}
+
+func TestAddBytes(t *testing.T) {
+	Convey("Adding byte sizes", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		r := agent.NewRequest("foo")
+		r.AddBytes(RestBytes, 100)
+		r.AddBytes(RestBytes, 50)
+		r.AddCount("rest_calls", 2)
+		So(r.bytes[RestBytes], ShouldEqual, 150)
+		So(r.counts, ShouldNotContainKey, RestBytes)
+		r.endTime = time.Now()
+		payload := r.logjamPayload(200)
+		So(payload["rest_bytes"], ShouldEqual, 150)
+		So(payload["rest_calls"], ShouldEqual, 2)
+	})
+}
+
+func TestAttachCount(t *testing.T) {
+	Convey("Attaching counters to the next request", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		agent.AttachCount("grpc_users_ready", 2)
+		agent.AttachCount("grpc_users_ready", 1)
+		ignored := agent.NewRequest("foo")
+		ignored.Ignore()
+		ignored.Finish(200)
+		r := agent.NewRequest("foo")
+		r.Count("grpc_users_ready")
+		r.Finish(200)
+		So(r.counts["grpc_users_ready"], ShouldEqual, 4)
+		So(agent.takeAttached(), ShouldBeEmpty)
+	})
+}
+
+func TestIgnore(t *testing.T) {
+	Convey("Ignoring requests", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		r := agent.NewRequest("foo")
+		So(r.Ignored(), ShouldBeFalse)
+		r.Ignore()
+		So(r.Ignored(), ShouldBeTrue)
+		r.Finish(200)
+		So(r.endTime.IsZero(), ShouldBeTrue)
+	})
+}
+
+func TestSampling(t *testing.T) {
+	Convey("Sampling", t, func() {
+		sampler := func(action string, r *http.Request) bool { return action == "sampled" }
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), Sampler: sampler})
+
+		Convey("uses the configured sampler", func() {
+			So(agent.NewRequest("sampled").Sampled(), ShouldBeTrue)
+			So(agent.NewRequest("other").Sampled(), ShouldBeFalse)
+		})
+
+		Convey("samples all requests by default", func() {
+			agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+			So(agent.NewRequest("other").Sampled(), ShouldBeTrue)
+		})
+
+		Convey("drops successful requests which have not been sampled", func() {
+			r := agent.NewRequest("other")
+			r.Finish(200)
+			So(r.endTime.IsZero(), ShouldBeTrue)
+		})
+
+		Convey("always considers failed requests", func() {
+			r := agent.NewRequest("other")
+			So(r.failed(200), ShouldBeFalse)
+			So(r.failed(500), ShouldBeTrue)
+			r.Log(ERROR, "boom")
+			So(r.failed(200), ShouldBeTrue)
+		})
+	})
+}
+
+func TestExceptionCounts(t *testing.T) {
+	Convey("Counting exceptions", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		r := agent.NewRequest("foo")
+		r.AddException("Timeout")
+		r.AddExceptionCount("UpstreamError", 37)
+		r.AddException("UpstreamError")
+		r.endTime = time.Now()
+		payload := r.logjamPayload(200)
+		So(payload["exceptions"], ShouldHaveLength, 2)
+		So(payload["exceptions"], ShouldContain, "Timeout")
+		So(payload["exceptions"], ShouldContain, "UpstreamError")
+		So(payload["exception_counts"], ShouldResemble, map[string]int{"Timeout": 1, "UpstreamError": 38})
+	})
+}
+
+func TestSoftExceptions(t *testing.T) {
+	Convey("Soft exceptions", t, func() {
+		agent := NewAgent(&Options{
+			Logger:         log.New(ioutil.Discard, "", 0),
+			SoftExceptions: []string{`\ANotFound\z`, `Retry`, `(`},
+		})
+		So(agent.soft, ShouldHaveLength, 2)
+
+		Convey("don't raise the severity", func() {
+			r := agent.NewRequest("foo")
+			r.AddException("NotFound")
+			r.AddException("UpstreamRetry")
+			So(r.severity, ShouldEqual, INFO)
+			So(r.exceptions, ShouldHaveLength, 2)
+		})
+
+		Convey("hard exceptions raise the severity to ERROR", func() {
+			r := agent.NewRequest("foo")
+			r.AddException("NotFoundError")
+			So(r.severity, ShouldEqual, ERROR)
+			r.Log(FATAL, "boom")
+			r.AddException("Timeout")
+			So(r.severity, ShouldEqual, FATAL)
+		})
+	})
+}
+
+func TestFieldTruncation(t *testing.T) {
+	Convey("Truncating fields", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), MaxFieldBytes: 100})
+		r := agent.NewRequest("foo")
+
+		Convey("keeps small values", func() {
+			r.SetField("small", "value")
+			r.SetField("number", 12345)
+			r.SetField("map", map[string]int{"a": 1})
+			So(r.GetField("small"), ShouldEqual, "value")
+			So(r.GetField("number"), ShouldEqual, 12345)
+			So(r.GetField("map"), ShouldResemble, map[string]int{"a": 1})
+		})
+
+		Convey("truncates large strings", func() {
+			r.SetField("large", strings.Repeat("x", 200))
+			v := r.GetField("large").(string)
+			So(v, ShouldHaveLength, 100)
+			So(v, ShouldEndWith, fieldTruncated)
+		})
+
+		Convey("doesn't split multi-byte characters", func() {
+			r.SetField("large", "x"+strings.Repeat("ä", 100))
+			v := r.GetField("large").(string)
+			So(v, ShouldHaveLength, 99)
+			So(utf8.ValidString(v), ShouldBeTrue)
+			So(v, ShouldEndWith, "ä"+fieldTruncated)
+		})
+
+		Convey("truncates the JSON representation of large values", func() {
+			r.SetField("large", []string{strings.Repeat("x", 200)})
+			v := r.GetField("large").(string)
+			So(v, ShouldHaveLength, 100)
+			So(v, ShouldStartWith, `["xxx`)
+			So(v, ShouldEndWith, fieldTruncated)
+		})
+	})
+}
+
+func TestEncodePayload(t *testing.T) {
+	Convey("Encoding payloads", t, func() {
+		Convey("stringifies values which can't be serialized", func() {
+			payload := map[string]interface{}{
+				"action": "foo",
+				"ratio":  math.NaN(),
+				"chan":   make(chan int),
+			}
+			buf, err := encodePayload(payload)
+			So(err, ShouldBeNil)
+			output := map[string]interface{}{}
+			So(json.Unmarshal(buf, &output), ShouldBeNil)
+			So(output["action"], ShouldEqual, "foo")
+			So(output["ratio"], ShouldEqual, "NaN")
+			So(output["chan"], ShouldStartWith, "0x")
+			So(output["encoding_errors"], ShouldHaveLength, 2)
+		})
+
+		Convey("leaves serializable payloads untouched", func() {
+			buf, err := encodePayload(map[string]interface{}{"action": "foo"})
+			So(err, ShouldBeNil)
+			So(string(buf), ShouldEqual, `{"action":"foo"}`)
+		})
+	})
+}
+
+func TestPoolRequests(t *testing.T) {
+	Convey("Pooling requests", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), PoolRequests: true})
+		r := agent.NewRequest("foo")
+		r.AddDuration("db_time", time.Millisecond)
+		r.AddCount("db_calls", 1)
+		r.SetField("foo", "bar")
+		r.Log(ERROR, "line")
+		r.AddException("X")
+		r.Ignore()
+		r.release()
+
+		So(r.agent, ShouldBeNil)
+		So(r.action, ShouldEqual, "")
+		So(r.durations, ShouldBeEmpty)
+		So(r.counts, ShouldBeEmpty)
+		So(r.fields, ShouldBeEmpty)
+		So(r.exceptions, ShouldBeEmpty)
+		So(r.logLines, ShouldBeEmpty)
+		So(r.severity, ShouldEqual, INFO)
+		So(r.ignored, ShouldBeFalse)
+	})
+
+	Convey("Finishing pooled requests twice", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), PoolRequests: true})
+		r := agent.NewRequest("foo")
+		r.Ignore()
+		r.Finish(200)
+		So(r.agent, ShouldBeNil)
+		So(func() { r.Finish(200) }, ShouldNotPanic)
+		So(agent.InFlight(), ShouldEqual, 0)
+	})
+
+	Convey("Not pooling requests of hijacked connections", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), PoolRequests: true})
+		r := agent.NewRequest("foo")
+		r.unpooled = true
+		r.Ignore()
+		r.Finish(200)
+		So(r.agent, ShouldEqual, agent)
+		So(func() { r.Count("websocket_messages_sent") }, ShouldNotPanic)
+	})
+}
+
+func requestLifecycle(agent *Agent) {
+	r := agent.NewRequest("foo")
+	r.AddDuration("db_time", time.Millisecond)
+	r.AddCount("db_calls", 1)
+	r.SetField("foo", "bar")
+	r.Log(INFO, "line")
+	r.endTime = time.Now()
+	encodePayload(r.logjamPayload(200))
+	if agent.PoolRequests {
+		r.release()
+	}
+}
+
+func BenchmarkRequestLifecycle(b *testing.B) {
+	agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		requestLifecycle(agent)
+	}
+}
+
+func BenchmarkPooledRequestLifecycle(b *testing.B) {
+	agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), PoolRequests: true})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		requestLifecycle(agent)
+	}
+}
+
+func BenchmarkLog(b *testing.B) {
+	agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+	line := strings.Repeat("x", 100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := agent.NewRequest("foo")
+		for j := 0; j < 10; j++ {
+			r.Log(INFO, line)
+		}
+	}
+}
+
+func TestSlowRequests(t *testing.T) {
+	Convey("Slow requests", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), SlowRequestThreshold: time.Second})
+		start := time.Now()
+
+		Convey("are annotated when exceeding the threshold", func() {
+			r := agent.NewRequestAt("foo", start)
+			r.AddDuration("rest_time", 400*time.Millisecond)
+			r.AddDuration("db_time", 300*time.Millisecond)
+			r.endTime = start.Add(1500 * time.Millisecond)
+			r.annotateSlowRequest()
+			So(r.exceptions[slowRequestException], ShouldEqual, 1)
+			So(r.severity, ShouldEqual, WARN)
+			So(r.logLines, ShouldHaveLength, 1)
+			So(r.logLines[0].message, ShouldEqual, "slow request: total_time=1.5s exceeds 1s (db_time=300ms, rest_time=400ms)")
+		})
+
+		Convey("are not annotated below the threshold", func() {
+			r := agent.NewRequestAt("foo", start)
+			r.endTime = start.Add(500 * time.Millisecond)
+			r.annotateSlowRequest()
+			So(r.exceptions, ShouldBeEmpty)
+			So(r.logLines, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestMerge(t *testing.T) {
+	Convey("Merging requests", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		main := agent.NewRequest("foo")
+		main.AddDuration("rest_time", 10*time.Millisecond)
+		main.AddCount("rest_calls", 1)
+
+		scratch := agent.NewScratchRequest("scratch")
+		So(agent.InFlight(), ShouldEqual, 1)
+		scratch.AddDuration("rest_time", 20*time.Millisecond)
+		scratch.AddDuration("db_time", 5*time.Millisecond)
+		scratch.AddCount("rest_calls", 2)
+		scratch.AddBytes(RestBytes, 100)
+		scratch.AddExceptionCount("Retry", 3)
+
+		main.Merge(scratch)
+		main.Merge(main)
+
+		So(main.durations, ShouldResemble, map[string]time.Duration{"rest_time": 30 * time.Millisecond, "db_time": 5 * time.Millisecond})
+		So(main.counts, ShouldResemble, map[string]int64{"rest_calls": 3})
+		So(main.bytes, ShouldResemble, map[string]int64{RestBytes: 100})
+		So(main.exceptions, ShouldResemble, map[string]int{"Retry": 3})
+		So(main.severity, ShouldEqual, ERROR)
+		So(scratch.counts, ShouldResemble, map[string]int64{"rest_calls": 2})
+
+		scratch.Finish(200)
+		So(agent.InFlight(), ShouldEqual, 1)
+	})
+}
+
+func TestCallerMetadata(t *testing.T) {
+	Convey("Setting caller metadata", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		r := agent.NewRequest("foo")
+		So(r.traceID, ShouldEqual, r.uuid)
+		r.SetCaller("app-env-123", "Users#show")
+		r.SetTraceID("abc")
+		r.endTime = time.Now()
+		payload := r.logjamPayload(200)
+		So(payload["caller_id"], ShouldEqual, "app-env-123")
+		So(payload["caller_action"], ShouldEqual, "Users#show")
+		So(payload["trace_id"], ShouldEqual, "abc")
+	})
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func TestGenerateID(t *testing.T) {
+	Convey("Generating request ids", t, func() {
+		Convey("uses the configured generator", func() {
+			agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), IDGenerator: func() string { return "01ARZ3NDEKTSV4RRFFQ69G5FAV" }})
+			r := agent.NewRequest("foo")
+			So(r.uuid, ShouldEqual, "01ARZ3NDEKTSV4RRFFQ69G5FAV")
+			So(r.id, ShouldEqual, "--01ARZ3NDEKTSV4RRFFQ69G5FAV")
+		})
+
+		Convey("falls back to math/rand if crypto/rand fails", func() {
+			reader := rand.Reader
+			rand.Reader = failingReader{}
+			defer func() { rand.Reader = reader }()
+
+			agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+			a, b := agent.generateID(), agent.generateID()
+			So(a, ShouldHaveLength, 32)
+			So(a, ShouldNotEqual, b)
+			So(a[12], ShouldEqual, '4')
+			So(agent.IDFallbacks(), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestDurationPrecision(t *testing.T) {
+	Convey("Duration precision", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		start := time.Now()
+
+		Convey("reports sub-millisecond durations", func() {
+			So(milliseconds(1234567*time.Nanosecond), ShouldEqual, 1.234)
+			r := agent.NewRequestAt("foo", start)
+			r.AddDuration("cache_time", 250*time.Microsecond)
+			r.endTime = start.Add(10 * time.Millisecond)
+			payload := r.logjamPayload(200)
+			So(payload["cache_time"], ShouldEqual, 0.25)
+			So(payload["total_time"], ShouldEqual, 10)
+		})
+
+		Convey("scales durations exceeding the total time", func() {
+			r := agent.NewRequestAt("foo", start)
+			r.AddDuration("rest_time", 1500*time.Microsecond)
+			r.AddDuration("db_time", 500*time.Microsecond)
+			r.endTime = start.Add(time.Millisecond)
+			payload := r.logjamPayload(200)
+			sum := payload["rest_time"].(float64) + payload["db_time"].(float64)
+			So(sum, ShouldBeLessThan, 1)
+			So(sum, ShouldAlmostEqual, 0.999, 0.0001)
+		})
+	})
+}
+
+func TestInFlight(t *testing.T) {
+	Convey("In flight requests", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		first := agent.NewRequest("First#call")
+		second := agent.NewRequest("Second#call")
+		So(agent.InFlight(), ShouldEqual, 2)
+		So(first.load, ShouldEqual, 1)
+		So(second.load, ShouldEqual, 2)
+		second.Ignore()
+		second.Finish(200)
+		second.Finish(200)
+		So(agent.InFlight(), ShouldEqual, 1)
+		second.endTime = time.Now()
+		So(second.logjamPayload(200)["load"], ShouldEqual, 2)
+	})
+}
+
+func TestActionHelpers(t *testing.T) {
+	Convey("Changing actions via the context", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		r := agent.NewRequest("Users#show")
+		ctx := r.NewContext(context.Background())
+
+		w := httptest.NewRecorder()
+		ChangeAction(ctx, w, "Users#index")
+		So(r.action, ShouldEqual, "Users#index")
+		So(w.Header().Get("X-Logjam-Action"), ShouldEqual, "Users#index")
+
+		SetAction(ctx, "Users#friends")
+		So(r.action, ShouldEqual, "Users#friends")
+
+		So(func() { SetAction(context.Background(), "Foo#bar") }, ShouldNotPanic)
+		So(func() { ChangeAction(context.Background(), w, "Foo#bar") }, ShouldNotPanic)
+	})
+}
@@ -0,0 +1,46 @@
+package resty
+
+import (
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/xing/logjam-agent-go"
+)
+
+// Install adds hooks to the given resty client, which add the logjam call headers of the
+// logjam request found in the request context, as set with SetContext, to outgoing requests
+// and record the time spent on them as rest_time and the number of calls as rest_calls on
+// that logjam request.
+func Install(client *resty.Client) {
+	client.OnBeforeRequest(setCallHeaders)
+	client.OnAfterResponse(recordResponse)
+	client.OnError(recordError)
+}
+
+func setCallHeaders(c *resty.Client, r *resty.Request) error {
+	for name, values := range logjam.CallHeaders(r.Context()) {
+		r.Header[name] = values
+	}
+	return nil
+}
+
+func recordResponse(c *resty.Client, res *resty.Response) error {
+	if request := logjam.GetRequest(res.Request.Context()); request != nil {
+		request.AddDuration("rest_time", res.Time())
+		request.Count("rest_calls")
+	}
+	return nil
+}
+
+// recordError records calls failing without response. Errors of calls with response,
+// e.g. returned by response middleware, have already been recorded by recordResponse.
+// Resty wraps transport errors in a ResponseError as well, but without raw response.
+func recordError(r *resty.Request, err error) {
+	if e, ok := err.(*resty.ResponseError); ok && e.Response != nil && e.Response.RawResponse != nil {
+		return
+	}
+	if request := logjam.GetRequest(r.Context()); request != nil {
+		request.AddDuration("rest_time", time.Since(r.Time))
+		request.Count("rest_calls")
+	}
+}
@@ -0,0 +1,44 @@
+package resty
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestInstall(t *testing.T) {
+	collector := logjamtest.NewCollector("resty-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	var action string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action = r.Header.Get("X-Logjam-Action")
+	}))
+	defer server.Close()
+	client := resty.New()
+	Install(client)
+
+	Convey("calling services with resty", t, func() {
+		request := agent.NewRequest("Users#show")
+		ctx := request.NewContext(context.Background())
+
+		res, err := client.R().SetContext(ctx).Get(server.URL)
+		So(err, ShouldBeNil)
+		So(res.StatusCode(), ShouldEqual, http.StatusOK)
+		So(action, ShouldEqual, "Users#show")
+		_, err = client.R().SetContext(ctx).Get("http://127.0.0.1:1/")
+		So(err, ShouldNotBeNil)
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output, ShouldContainKey, "rest_time")
+		So(output["rest_calls"], ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"context"
+	"net/rpc"
+	"time"
+
+	"github.com/xing/logjam-agent-go"
+)
+
+// Envelope carries the logjam caller id, caller action and trace id of net/rpc calls. Embed
+// it in argument types to have Client fill it in, and call Apply on the server side:
+//
+//	type ShowArgs struct {
+//		rpc.Envelope
+//		ID int
+//	}
+type Envelope struct {
+	LogjamCallerID     string
+	LogjamCallerAction string
+	LogjamTraceID      string
+}
+
+// envelope is implemented by argument types embedding an Envelope.
+type envelope interface {
+	envelope() *Envelope
+}
+
+func (e *Envelope) envelope() *Envelope {
+	return e
+}
+
+// Apply sets the caller and trace id of the given logjam request from the envelope.
+func (e *Envelope) Apply(request *logjam.Request) {
+	request.SetCaller(e.LogjamCallerID, e.LogjamCallerAction)
+	if e.LogjamTraceID != "" {
+		request.SetTraceID(e.LogjamTraceID)
+	}
+}
+
+// Client wraps a net/rpc client, recording the time spent on calls made with a context
+// holding a logjam request on that request.
+type Client struct {
+	*rpc.Client
+	TimeKey  string // Key of the call duration metric, defaults to rpc_time.
+	CallsKey string // Key of the call counter, defaults to rpc_calls.
+}
+
+// NewClient wraps the given client, recording call durations as rpc_time and the number
+// of calls as rpc_calls.
+func NewClient(client *rpc.Client) *Client {
+	return &Client{Client: client, TimeKey: "rpc_time", CallsKey: "rpc_calls"}
+}
+
+// CallContext calls the named function like rpc.Client.Call does. Arguments embedding an
+// Envelope get the caller id, action and trace id of the logjam request found in the
+// context, provided they are passed as pointer. The context is not used to cancel the call.
+func (c *Client) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return c.Call(serviceMethod, args, reply)
+	}
+	if e, ok := args.(envelope); ok {
+		headers := logjam.CallHeaders(ctx)
+		env := e.envelope()
+		env.LogjamCallerID = headers.Get("X-Logjam-Caller-Id")
+		env.LogjamCallerAction = headers.Get("X-Logjam-Action")
+		env.LogjamTraceID = request.TraceID()
+	}
+	start := time.Now()
+	err := c.Call(serviceMethod, args, reply)
+	request.AddDuration(defaultString(c.TimeKey, "rpc_time"), time.Since(start))
+	request.Count(defaultString(c.CallsKey, "rpc_calls"))
+	return err
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+type EchoArgs struct {
+	Envelope
+	Text string
+}
+
+type Echo struct {
+	received chan Envelope
+}
+
+func (e *Echo) Say(args EchoArgs, reply *string) error {
+	e.received <- args.Envelope
+	*reply = args.Text
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	collector := logjamtest.NewCollector("rpc-test", logjam.Options{
+		AppName: "appName",
+		EnvName: "envName",
+	})
+	defer collector.Close()
+	agent := collector.Agent
+
+	echo := &Echo{received: make(chan Envelope, 1)}
+	server := rpc.NewServer()
+	server.Register(echo)
+	clientConn, serverConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client := NewClient(rpc.NewClient(clientConn))
+	client.TimeKey = "echo_time"
+	client.CallsKey = "echo_calls"
+	defer client.Close()
+
+	Convey("calling with envelopes", t, func() {
+		request := agent.NewRequest("Users#show")
+		ctx := request.NewContext(context.Background())
+
+		var reply string
+		So(client.CallContext(ctx, "Echo.Say", &EchoArgs{Text: "hello"}, &reply), ShouldBeNil)
+		So(reply, ShouldEqual, "hello")
+		envelope := <-echo.received
+		So(envelope.LogjamCallerID, ShouldStartWith, "appName-envName-")
+		So(envelope.LogjamCallerAction, ShouldEqual, "Users#show")
+		So(envelope.LogjamTraceID, ShouldEqual, request.TraceID())
+
+		callee := agent.NewRequest("Echo#say")
+		envelope.Apply(callee)
+		So(callee.TraceID(), ShouldEqual, request.TraceID())
+		callee.Ignore()
+		callee.Finish(200)
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output, ShouldContainKey, "echo_time")
+		So(output["echo_calls"], ShouldEqual, 1)
+	})
+}
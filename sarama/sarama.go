@@ -0,0 +1,36 @@
+package sarama
+
+import (
+	"context"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/xing/logjam-agent-go"
+)
+
+// AddHeaders adds the logjam call headers of the logjam request found in the context to
+// the record headers of the message, like logjam.SetCallHeaders does for HTTP requests,
+// so that consumers can link their logjam requests to the producing request.
+func AddHeaders(ctx context.Context, msg *sarama.ProducerMessage) {
+	for name, values := range logjam.CallHeaders(ctx) {
+		for _, value := range values {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(value)})
+		}
+	}
+}
+
+// SendMessage adds the logjam call headers to the message and sends it using the given
+// producer, recording the time spent as kafka_publish_time and the number of messages
+// sent as kafka_publish_calls on the logjam request found in the context.
+func SendMessage(ctx context.Context, producer sarama.SyncProducer, msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return producer.SendMessage(msg)
+	}
+	AddHeaders(ctx, msg)
+	start := time.Now()
+	partition, offset, err = producer.SendMessage(msg)
+	request.AddDuration("kafka_publish_time", time.Since(start))
+	request.Count("kafka_publish_calls")
+	return partition, offset, err
+}
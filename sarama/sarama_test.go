@@ -0,0 +1,60 @@
+package sarama
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+// fakeProducer records the messages sent. Other SyncProducer methods aren't implemented.
+type fakeProducer struct {
+	sarama.SyncProducer
+	sent []*sarama.ProducerMessage
+}
+
+func (p *fakeProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent)), nil
+}
+
+func TestSendMessage(t *testing.T) {
+	collector := logjamtest.NewCollector("sarama-test", logjam.Options{
+		AppName: "appName",
+		EnvName: "envName",
+	})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("sending messages with call headers", t, func() {
+		request := agent.NewRequest("Users#create")
+		ctx := request.NewContext(context.Background())
+		producer := &fakeProducer{}
+
+		_, offset, err := SendMessage(ctx, producer, &sarama.ProducerMessage{Topic: "users", Value: sarama.StringEncoder("created")})
+		So(err, ShouldBeNil)
+		So(offset, ShouldEqual, 1)
+		headers := map[string]string{}
+		for _, header := range producer.sent[0].Headers {
+			headers[string(header.Key)] = string(header.Value)
+		}
+		So(headers["X-Logjam-Action"], ShouldEqual, "Users#create")
+		So(headers["X-Logjam-Caller-Id"], ShouldStartWith, "appName-envName-")
+		So(headers, ShouldContainKey, "X-Logjam-Trace-Id")
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output, ShouldContainKey, "kafka_publish_time")
+		So(output["kafka_publish_calls"], ShouldEqual, 1)
+	})
+
+	Convey("sending messages without logjam request", t, func() {
+		producer := &fakeProducer{}
+		_, _, err := SendMessage(context.Background(), producer, &sarama.ProducerMessage{Topic: "users"})
+		So(err, ShouldBeNil)
+		So(producer.sent[0].Headers, ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,33 @@
+package logjam
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ServeMuxActionNameExtractor returns an extractor deriving action names from the
+// ServeMux pattern matching the request, so that "GET /users/{id}" results in
+// "Users::Id#get". With Go 1.23 or later, the pattern recorded in http.Request.Pattern
+// takes precedence over the one matched by the given mux, which may be nil. Requests
+// without a matching pattern get their action name from DefaultActionNameExtractor.
+func ServeMuxActionNameExtractor(mux *http.ServeMux) ActionNameExtractor {
+	return func(r *http.Request) string {
+		pattern := requestPattern(r)
+		if pattern == "" && mux != nil {
+			_, pattern = mux.Handler(r)
+		}
+		if pattern == "" {
+			return DefaultActionNameExtractor(r)
+		}
+		return patternActionName(r.Method, pattern)
+	}
+}
+
+// patternActionName strips the method and host from a ServeMux pattern and derives the
+// action name from the remaining path template.
+func patternActionName(method, pattern string) string {
+	if i := strings.IndexByte(pattern, '/'); i >= 0 {
+		pattern = pattern[i:]
+	}
+	return RouteTemplateActionName(method, strings.TrimSuffix(pattern, "{$}"))
+}
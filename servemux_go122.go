@@ -0,0 +1,10 @@
+//go:build !go1.23
+
+package logjam
+
+import "net/http"
+
+// requestPattern returns an empty pattern, as http.Request.Pattern was added in Go 1.23.
+func requestPattern(r *http.Request) string {
+	return ""
+}
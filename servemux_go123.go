@@ -0,0 +1,10 @@
+//go:build go1.23
+
+package logjam
+
+import "net/http"
+
+// requestPattern returns the ServeMux pattern recorded on the request.
+func requestPattern(r *http.Request) string {
+	return r.Pattern
+}
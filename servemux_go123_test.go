@@ -0,0 +1,20 @@
+//go:build go1.23
+
+package logjam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServeMuxRequestPattern(t *testing.T) {
+	Convey("preferring the pattern recorded on the request", t, func() {
+		extract := ServeMuxActionNameExtractor(http.NewServeMux())
+		r := httptest.NewRequest("GET", "/users/abc", nil)
+		r.Pattern = "GET /people/{id}"
+		So(extract(r), ShouldEqual, "People::Id#get")
+	})
+}
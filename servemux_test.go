@@ -0,0 +1,32 @@
+package logjam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServeMuxActionNameExtractor(t *testing.T) {
+	Convey("ServeMuxActionNameExtractor", t, func() {
+		Convey("deriving action names from patterns", func() {
+			So(patternActionName("GET", "GET /users/{id}"), ShouldEqual, "Users::Id#get")
+			So(patternActionName("POST", "example.com/users/{id}/friends/"), ShouldEqual, "Users::Id::Friends#post")
+			So(patternActionName("GET", "/files/{path...}"), ShouldEqual, "Files::Id#get")
+			So(patternActionName("GET", "GET /{$}"), ShouldEqual, "Unknown#get")
+		})
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /users/{id}", func(http.ResponseWriter, *http.Request) {})
+		extract := ServeMuxActionNameExtractor(mux)
+
+		Convey("using the pattern matched by the mux", func() {
+			So(extract(httptest.NewRequest("GET", "/users/abc", nil)), ShouldEqual, "Users::Id#get")
+		})
+
+		Convey("falling back to the default extractor", func() {
+			So(extract(httptest.NewRequest("GET", "/groups/123", nil)), ShouldEqual, "Groups::Id#get")
+		})
+	})
+}
@@ -0,0 +1,131 @@
+//go:build go1.21
+// +build go1.21
+
+package slog
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/xing/logjam-agent-go"
+)
+
+// Handler implements slog.Handler. It forwards records to the logjam request found in the
+// context passed to the logging methods of slog.Logger, e.g. logger.InfoContext(ctx, ...),
+// and optionally to a wrapped handler. The attributes of a record are appended to the
+// message as key=value pairs. Levels below slog.LevelInfo are mapped to DEBUG, levels
+// above slog.LevelError to FATAL.
+type Handler struct {
+	next   slog.Handler // The wrapped handler, may be nil.
+	attrs  string       // Preformatted attributes added by WithAttrs.
+	prefix string       // Key prefix of the groups opened by WithGroup.
+}
+
+// NewHandler creates a handler which forwards records to logjam and to the given handler,
+// unless it is nil.
+func NewHandler(next slog.Handler) *Handler {
+	return &Handler{next: next}
+}
+
+// Enabled reports whether the context holds a logjam request or the wrapped handler is
+// enabled for the given level. Log lines below the log level of the agent are dropped by
+// the logjam request.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	if logjam.GetRequest(ctx) != nil {
+		return true
+	}
+	return h.next != nil && h.next.Enabled(ctx, level)
+}
+
+// Handle adds the record as log line to the logjam request found in the context and passes
+// it on to the wrapped handler.
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	if request := logjam.GetRequest(ctx); request != nil {
+		var b strings.Builder
+		b.WriteString(record.Message)
+		b.WriteString(h.attrs)
+		record.Attrs(func(attr slog.Attr) bool {
+			appendAttr(&b, h.prefix, attr)
+			return true
+		})
+		request.Log(severity(record.Level), b.String())
+	}
+	if h.next != nil && h.next.Enabled(ctx, record.Level) {
+		return h.next.Handle(ctx, record)
+	}
+	return nil
+}
+
+// WithAttrs returns a handler which adds the given attributes to all records.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, attr := range attrs {
+		appendAttr(&b, h.prefix, attr)
+	}
+	clone := *h
+	clone.attrs = b.String()
+	if h.next != nil {
+		clone.next = h.next.WithAttrs(attrs)
+	}
+	return &clone
+}
+
+// WithGroup returns a handler which qualifies the keys of all following attributes with
+// the given group name.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	if h.next != nil {
+		clone.next = h.next.WithGroup(name)
+	}
+	return &clone
+}
+
+func severity(level slog.Level) logjam.LogLevel {
+	switch {
+	case level < slog.LevelInfo:
+		return logjam.DEBUG
+	case level < slog.LevelWarn:
+		return logjam.INFO
+	case level < slog.LevelError:
+		return logjam.WARN
+	case level == slog.LevelError:
+		return logjam.ERROR
+	default:
+		return logjam.FATAL
+	}
+}
+
+func appendAttr(b *strings.Builder, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range value.Group() {
+			appendAttr(b, prefix, member)
+		}
+		return
+	}
+	b.WriteByte(' ')
+	b.WriteString(prefix)
+	b.WriteString(attr.Key)
+	b.WriteByte('=')
+	s := value.String()
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		s = strconv.Quote(s)
+	}
+	b.WriteString(s)
+}
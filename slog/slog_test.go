@@ -0,0 +1,58 @@
+//go:build go1.21
+// +build go1.21
+
+package slog
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestHandler(t *testing.T) {
+	collector := logjamtest.NewCollector("slog-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("forwarding records to logjam", t, func() {
+		var buffer bytes.Buffer
+		text := slog.NewTextHandler(&buffer, &slog.HandlerOptions{
+			Level: slog.LevelWarn,
+			ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+				if attr.Key == slog.TimeKey && len(groups) == 0 {
+					return slog.Attr{}
+				}
+				return attr
+			},
+		})
+		logger := slog.New(NewHandler(text)).With("app", "users").WithGroup("req")
+
+		request := agent.NewRequest("Users#show")
+		ctx := request.NewContext(context.Background())
+		logger.DebugContext(ctx, "looking up", "id", 42)
+		logger.WarnContext(ctx, "not found", slog.Group("user", "name", "John Doe"))
+		logger.Log(ctx, slog.LevelError+4, "giving up")
+		logger.ErrorContext(context.Background(), "without request")
+		request.Finish(404)
+
+		output := collector.Receive()
+		So(output["severity"], ShouldEqual, float64(logjam.FATAL))
+		lines := output["lines"].([]interface{})
+		So(lines, ShouldHaveLength, 3)
+		So(lines[0].([]interface{})[0], ShouldEqual, float64(logjam.DEBUG))
+		So(lines[0].([]interface{})[2], ShouldEqual, "looking up app=users req.id=42")
+		So(lines[1].([]interface{})[0], ShouldEqual, float64(logjam.WARN))
+		So(lines[1].([]interface{})[2], ShouldEqual, `not found app=users req.user.name="John Doe"`)
+		So(lines[2].([]interface{})[0], ShouldEqual, float64(logjam.FATAL))
+
+		So(buffer.String(), ShouldEqual, `level=WARN msg="not found" app=users req.user.name="John Doe"
+level=ERROR+4 msg="giving up" app=users
+level=ERROR msg="without request" app=users
+`)
+	})
+}
@@ -0,0 +1,116 @@
+package soap
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xing/logjam-agent-go"
+)
+
+// Client calls SOAP or other XML backends over HTTP, adding the logjam call headers of the
+// logjam request found in the context to requests and recording on that logjam request:
+// the time spent as <metric>_time, the number of calls as <metric>_calls and the sizes of
+// requests and responses as <metric>_request_bytes and <metric>_response_bytes. Responses
+// containing a SOAP fault are logged as WARN lines.
+type Client struct {
+	HTTPClient *http.Client // Client used for calls, defaults to http.DefaultClient.
+	Metric     string       // Name of the recorded metrics, defaults to "soap".
+}
+
+// Call posts the given envelope to the URL and returns the response along with its body.
+// The SOAPAction header is set if action is not empty. Responses with status codes other
+// than 200 are no error, as SOAP faults come with status code 500.
+func (c *Client) Call(ctx context.Context, url, action string, envelope []byte) (*http.Response, []byte, error) {
+	r, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(envelope))
+	if err != nil {
+		return nil, nil, err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	if action != "" {
+		r.Header.Set("SOAPAction", `"`+action+`"`)
+	}
+	return c.Do(r)
+}
+
+// Do sends the request and reads the response body, recording the call on the logjam
+// request found in the request context.
+func (c *Client) Do(r *http.Request) (*http.Response, []byte, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	metric := c.Metric
+	if metric == "" {
+		metric = "soap"
+	}
+	request := logjam.GetRequest(r.Context())
+	if request == nil {
+		return do(client, r)
+	}
+	logjam.SetCallHeaders(r.Context(), r)
+	start := time.Now()
+	res, body, err := do(client, r)
+	request.AddDuration(metric+"_time", time.Since(start))
+	request.Count(metric + "_calls")
+	if r.ContentLength > 0 {
+		request.AddBytes(metric+"_request_bytes", r.ContentLength)
+	}
+	request.AddBytes(metric+"_response_bytes", int64(len(body)))
+	if fault := findFault(body); fault != "" {
+		request.Log(logjam.WARN, "SOAP fault from "+r.URL.Host+": "+fault)
+	}
+	return res, body, err
+}
+
+func do(client *http.Client, r *http.Request) (*http.Response, []byte, error) {
+	res, err := client.Do(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	return res, body, err
+}
+
+// fault holds the fields of SOAP 1.1 and SOAP 1.2 faults.
+type fault struct {
+	Code   string `xml:"faultcode"`
+	String string `xml:"faultstring"`
+	Code12 struct {
+		Value string `xml:"Value"`
+	} `xml:"Code"`
+	Reason struct {
+		Text string `xml:"Text"`
+	} `xml:"Reason"`
+}
+
+// findFault returns a description of the SOAP fault contained in the body, or "" if there
+// is none.
+func findFault(body []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Fault" {
+			continue
+		}
+		var f fault
+		if decoder.DecodeElement(&f, &start) != nil {
+			return "unparsable fault"
+		}
+		code, text := f.Code, f.String
+		if code == "" {
+			code, text = f.Code12.Value, f.Reason.Text
+		}
+		return strings.TrimSpace(code + " " + text)
+	}
+}
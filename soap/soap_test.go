@@ -0,0 +1,73 @@
+package soap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+const faultResponse = `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Body>
+    <soap:Fault>
+      <faultcode>soap:Server</faultcode>
+      <faultstring>Customer not found</faultstring>
+    </soap:Fault>
+  </soap:Body>
+</soap:Envelope>`
+
+func TestFindFault(t *testing.T) {
+	Convey("finding SOAP faults", t, func() {
+		So(findFault([]byte(faultResponse)), ShouldEqual, "soap:Server Customer not found")
+		So(findFault([]byte(`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><env:Fault>
+			<env:Code><env:Value>env:Sender</env:Value></env:Code>
+			<env:Reason><env:Text xml:lang="en">Invalid id</env:Text></env:Reason>
+			</env:Fault></env:Body></env:Envelope>`)), ShouldEqual, "env:Sender Invalid id")
+		So(findFault([]byte(`<Envelope><Body><Customer/></Body></Envelope>`)), ShouldEqual, "")
+		So(findFault([]byte(`not xml`)), ShouldEqual, "")
+	})
+}
+
+func TestCall(t *testing.T) {
+	collector := logjamtest.NewCollector("soap-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	var action, soapAction string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action = r.Header.Get("X-Logjam-Action")
+		soapAction = r.Header.Get("SOAPAction")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(faultResponse))
+	}))
+	defer server.Close()
+
+	Convey("calling SOAP backends", t, func() {
+		request := agent.NewRequest("Customers#show")
+		ctx := request.NewContext(context.Background())
+		client := &Client{Metric: "crm"}
+
+		envelope := []byte(`<soap:Envelope><soap:Body><GetCustomer/></soap:Body></soap:Envelope>`)
+		res, body, err := client.Call(ctx, server.URL, "GetCustomer", envelope)
+		So(err, ShouldBeNil)
+		So(res.StatusCode, ShouldEqual, http.StatusInternalServerError)
+		So(string(body), ShouldEqual, faultResponse)
+		So(action, ShouldEqual, "Customers#show")
+		So(soapAction, ShouldEqual, `"GetCustomer"`)
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output, ShouldContainKey, "crm_time")
+		So(output["crm_calls"], ShouldEqual, 1)
+		So(output["crm_request_bytes"], ShouldEqual, len(envelope))
+		So(output["crm_response_bytes"], ShouldEqual, len(faultResponse))
+		lines := output["lines"].([]interface{})
+		So(lines, ShouldHaveLength, 1)
+		So(lines[0].([]interface{})[2], ShouldEqual, "SOAP fault from "+server.Listener.Addr().String()+": soap:Server Customer not found")
+	})
+}
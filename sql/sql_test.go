@@ -0,0 +1,95 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+// fakeDriver is a database driver accepting any statement and returning no rows.
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                                    { return nil }
+func (fakeStmt) NumInput() int                                   { return -1 }
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error)  { return fakeRows{}, nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return []string{"id"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func TestStatementKind(t *testing.T) {
+	Convey("classifying statements", t, func() {
+		So(statementKind("SELECT * FROM users"), ShouldEqual, "select")
+		So(statementKind("  (select 1)"), ShouldEqual, "select")
+		So(statementKind("WITH t AS (SELECT 1) SELECT * FROM t"), ShouldEqual, "select")
+		So(statementKind("insert into users values (1)"), ShouldEqual, "insert")
+		So(statementKind("UPDATE users SET name = ''"), ShouldEqual, "update")
+		So(statementKind("DELETE FROM users"), ShouldEqual, "delete")
+		So(statementKind("CREATE TABLE users (id int)"), ShouldEqual, "other")
+	})
+}
+
+func TestWrap(t *testing.T) {
+	collector := logjamtest.NewCollector("sql-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	sql.Register("logjam-fake", Wrap(fakeDriver{}))
+	db, err := sql.Open("logjam-fake", "")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	Convey("recording statements on the logjam request", t, func() {
+		request := agent.NewRequest("Users#index")
+		ctx := request.NewContext(context.Background())
+
+		rows, err := db.QueryContext(ctx, "SELECT id FROM users WHERE id = ?", 1)
+		So(err, ShouldBeNil)
+		rows.Close()
+		_, err = db.ExecContext(ctx, "UPDATE users SET name = ?", "x")
+		So(err, ShouldBeNil)
+		tx, err := db.BeginTx(ctx, nil)
+		So(err, ShouldBeNil)
+		_, err = tx.ExecContext(ctx, "DELETE FROM users")
+		So(err, ShouldBeNil)
+		So(tx.Commit(), ShouldBeNil)
+		_, err = db.Exec("DELETE FROM users")
+		So(err, ShouldBeNil)
+		request.Finish(200)
+
+		output := collector.Receive()
+
+		So(output, ShouldContainKey, "db_time")
+		So(output["db_calls"], ShouldEqual, 5)
+		So(output["db_select_calls"], ShouldEqual, 1)
+		So(output["db_update_calls"], ShouldEqual, 1)
+		So(output["db_delete_calls"], ShouldEqual, 1)
+		So(output["db_transaction_calls"], ShouldEqual, 2)
+	})
+}
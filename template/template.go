@@ -0,0 +1,58 @@
+package template
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/xing/logjam-agent-go"
+)
+
+// Execute applies the template to the data like t.Execute does, recording the time spent
+// rendering as view_time on the logjam request found in the context. Each rendering is
+// counted as view_calls and per template, e.g. as view_users_show_html_calls for a
+// template named "users/show.html".
+func Execute(ctx context.Context, t *template.Template, w io.Writer, data interface{}) error {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return t.Execute(w, data)
+	}
+	start := time.Now()
+	err := t.Execute(w, data)
+	record(request, t.Name(), start)
+	return err
+}
+
+// ExecuteTemplate applies the template with the given name like t.ExecuteTemplate does,
+// recording the rendering like Execute.
+func ExecuteTemplate(ctx context.Context, t *template.Template, w io.Writer, name string, data interface{}) error {
+	request := logjam.GetRequest(ctx)
+	if request == nil {
+		return t.ExecuteTemplate(w, name, data)
+	}
+	start := time.Now()
+	err := t.ExecuteTemplate(w, name, data)
+	record(request, name, start)
+	return err
+}
+
+func record(request *logjam.Request, name string, start time.Time) {
+	request.AddDuration("view_time", time.Since(start))
+	request.Count("view_calls")
+	request.Count("view_" + metricName(name) + "_calls")
+}
+
+// metricName turns a template name into a metric name component by lowercasing it and
+// replacing all characters other than letters and digits with underscores.
+func metricName(name string) string {
+	name = strings.Map(func(c rune) rune {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			return unicode.ToLower(c)
+		}
+		return '_'
+	}, name)
+	return strings.Trim(name, "_")
+}
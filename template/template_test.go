@@ -0,0 +1,46 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+)
+
+func TestMetricName(t *testing.T) {
+	Convey("naming template metrics", t, func() {
+		So(metricName("users/show.html"), ShouldEqual, "users_show_html")
+		So(metricName("Layout"), ShouldEqual, "layout")
+		So(metricName("/partials/_header.tmpl"), ShouldEqual, "partials__header_tmpl")
+	})
+}
+
+func TestExecute(t *testing.T) {
+	collector := logjamtest.NewCollector("template-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("recording template rendering", t, func() {
+		tmpl := template.Must(template.New("users/show.html").Parse(`{{define "name"}}<b>{{.}}</b>{{end}}Hello {{template "name" .}}`))
+		request := agent.NewRequest("Users#show")
+		ctx := request.NewContext(context.Background())
+
+		var out bytes.Buffer
+		So(Execute(ctx, tmpl, &out, "<Alice>"), ShouldBeNil)
+		So(out.String(), ShouldEqual, "Hello <b>&lt;Alice&gt;</b>")
+		out.Reset()
+		So(ExecuteTemplate(ctx, tmpl, &out, "name", "Bob"), ShouldBeNil)
+		So(out.String(), ShouldEqual, "<b>Bob</b>")
+		request.Finish(200)
+
+		output := collector.Receive()
+		So(output, ShouldContainKey, "view_time")
+		So(output["view_calls"], ShouldEqual, 2)
+		So(output["view_users_show_html_calls"], ShouldEqual, 1)
+		So(output["view_name_calls"], ShouldEqual, 1)
+	})
+}
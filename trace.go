@@ -0,0 +1,118 @@
+package logjam
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TracePolicy determines whether the middleware continues the trace of an incoming request
+// or mints a new trace id.
+type TracePolicy int
+
+const (
+	// TraceContinue reuses the trace id of the incoming request, if any, and mints a new
+	// trace id otherwise.
+	TraceContinue TracePolicy = iota
+	// TraceContinueFromCaller reuses the trace id of the incoming request only if it comes
+	// from a logjam instrumented caller, i.e. carries a X-Logjam-Caller-Id header.
+	TraceContinueFromCaller
+	// TraceNew always mints a new trace id.
+	TraceNew
+)
+
+// incomingTraceID returns the trace id to be used for the given incoming HTTP request
+// according to the trace policy of the agent. An empty string means that a new trace id
+// must be minted. The trace header of the agent takes precedence over a W3C traceparent
+// header.
+func (a *Agent) incomingTraceID(r *http.Request) string {
+	if !a.continuesTrace(r) {
+		return ""
+	}
+	if traceID := r.Header.Get(a.TraceHeader); traceID != "" {
+		return traceID
+	}
+	return traceIDFromTraceparent(r.Header.Get("traceparent"))
+}
+
+// continuesTrace returns whether the trace policy of the agent allows continuing the trace
+// of the given incoming HTTP request.
+func (a *Agent) continuesTrace(r *http.Request) bool {
+	switch a.TracePolicy {
+	case TraceNew:
+		return false
+	case TraceContinueFromCaller:
+		return r.Header.Get("X-Logjam-Caller-Id") != ""
+	}
+	return true
+}
+
+// traceIDFromTraceparent extracts the trace id from a W3C traceparent header value of the
+// form version-traceid-parentid-flags. Returns an empty string for invalid values.
+func traceIDFromTraceparent(traceparent string) string {
+	traceID, _ := parseTraceparent(traceparent)
+	return traceID
+}
+
+// parseTraceparent returns trace id and trace flags of a W3C traceparent header value.
+// Returns empty strings for invalid values.
+func parseTraceparent(traceparent string) (traceID string, flags string) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", ""
+	}
+	if !isValidTraceID(parts[1]) || len(parts[3]) < 2 || !isLowerHex(parts[3][:2]) {
+		return "", ""
+	}
+	return parts[1], parts[3][:2]
+}
+
+// traceContext sets trace flags and trace state of the logjam request from the W3C trace
+// context headers of the incoming HTTP request, provided the traceparent header belongs
+// to the trace the logjam request continues.
+func (r *Request) traceContext(incoming *http.Request) {
+	traceID, flags := parseTraceparent(incoming.Header.Get("traceparent"))
+	if traceID == "" || traceID != r.traceID {
+		return
+	}
+	r.traceFlags = flags
+	r.traceState = incoming.Header.Get("tracestate")
+}
+
+// traceparent returns a W3C traceparent header value for calls made on behalf of the
+// request, using the leading half of the request uuid as parent id. Returns an empty
+// string if the trace id of the request can't be represented as a W3C trace id.
+func (r *Request) traceparent() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !isValidTraceID(r.traceID) {
+		return ""
+	}
+	flags := r.traceFlags
+	if flags == "" {
+		flags = "01"
+	}
+	return "00-" + r.traceID + "-" + r.spanID() + "-" + flags
+}
+
+// spanID returns a 16 digit hex id identifying the request in outgoing trace headers. It
+// is derived from the request uuid if possible.
+func (r *Request) spanID() string {
+	id := r.uuid
+	if len(id) < 16 || !isLowerHex(id[:16]) || id[:16] == strings.Repeat("0", 16) {
+		id, _ = generateUUID()
+	}
+	return id[:16]
+}
+
+func isValidTraceID(traceID string) bool {
+	return len(traceID) == 32 && isLowerHex(traceID) && traceID != strings.Repeat("0", 32)
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package logjam
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIncomingTraceID(t *testing.T) {
+	Convey("Incoming trace ids", t, func() {
+		traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+		traceparent := "00-" + traceID + "-00f067aa0ba902b7-01"
+
+		newAgent := func(policy TracePolicy) *Agent {
+			return NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), TracePolicy: policy})
+		}
+
+		Convey("TraceContinue", func() {
+			agent := newAgent(TraceContinue)
+			r := httptest.NewRequest("GET", "/", nil)
+			So(agent.incomingTraceID(r), ShouldEqual, "")
+			r.Header.Set("traceparent", traceparent)
+			So(agent.incomingTraceID(r), ShouldEqual, traceID)
+			r.Header.Set("X-Logjam-Trace-Id", "logjamtrace")
+			So(agent.incomingTraceID(r), ShouldEqual, "logjamtrace")
+		})
+
+		Convey("configured trace header", func() {
+			agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0), TraceHeader: "X-Request-Id"})
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Logjam-Trace-Id", "logjamtrace")
+			So(agent.incomingTraceID(r), ShouldEqual, "")
+			r.Header.Set("X-Request-Id", "requestid")
+			So(agent.incomingTraceID(r), ShouldEqual, "requestid")
+		})
+
+		Convey("TraceContinueFromCaller", func() {
+			agent := newAgent(TraceContinueFromCaller)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Logjam-Trace-Id", "logjamtrace")
+			So(agent.incomingTraceID(r), ShouldEqual, "")
+			r.Header.Set("X-Logjam-Caller-Id", "app-env-123")
+			So(agent.incomingTraceID(r), ShouldEqual, "logjamtrace")
+		})
+
+		Convey("TraceNew", func() {
+			agent := newAgent(TraceNew)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Logjam-Caller-Id", "app-env-123")
+			r.Header.Set("X-Logjam-Trace-Id", "logjamtrace")
+			So(agent.incomingTraceID(r), ShouldEqual, "")
+		})
+
+		Convey("invalid traceparent headers", func() {
+			So(traceIDFromTraceparent(""), ShouldEqual, "")
+			So(traceIDFromTraceparent("ff-"+traceID+"-00f067aa0ba902b7-01"), ShouldEqual, "")
+			So(traceIDFromTraceparent("00-00000000000000000000000000000000-00f067aa0ba902b7-01"), ShouldEqual, "")
+			So(traceIDFromTraceparent("00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"), ShouldEqual, "")
+			So(traceIDFromTraceparent("00-"+traceID+"-01"), ShouldEqual, "")
+		})
+	})
+}
+
+func TestTraceContext(t *testing.T) {
+	Convey("W3C trace context", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+
+		Convey("continues incoming traces", func() {
+			var outgoing *http.Request
+			handler := agent.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				outgoing = httptest.NewRequest("GET", "/", nil)
+				SetCallHeaders(r.Context(), outgoing)
+			}), MiddlewareOptions{})
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-00")
+			r.Header.Set("tracestate", "congo=t61rcWkgMzE")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			parts := strings.Split(outgoing.Header.Get("traceparent"), "-")
+			So(parts, ShouldHaveLength, 4)
+			So(parts[1], ShouldEqual, traceID)
+			So(parts[2], ShouldNotEqual, "00f067aa0ba902b7")
+			So(parts[3], ShouldEqual, "00")
+			So(outgoing.Header.Get("tracestate"), ShouldEqual, "congo=t61rcWkgMzE")
+			So(outgoing.Header.Get("X-Logjam-Trace-Id"), ShouldEqual, traceID)
+		})
+
+		Convey("starts new traces", func() {
+			r := agent.NewRequest("foobar")
+			So(r.traceparent(), ShouldEqual, "00-"+r.uuid+"-"+r.uuid[:16]+"-01")
+		})
+
+		Convey("ignores trace ids which aren't W3C trace ids", func() {
+			r := agent.NewRequest("foobar")
+			r.SetTraceID("not-a-w3c-trace-id")
+			So(r.traceparent(), ShouldEqual, "")
+		})
+
+		Convey("ignores trace context of other traces", func() {
+			r := agent.NewRequest("foobar")
+			incoming := httptest.NewRequest("GET", "/", nil)
+			incoming.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-00")
+			incoming.Header.Set("tracestate", "congo=t61rcWkgMzE")
+			r.traceContext(incoming)
+			So(r.traceFlags, ShouldEqual, "")
+			So(r.traceState, ShouldEqual, "")
+		})
+	})
+}
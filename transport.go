@@ -0,0 +1,80 @@
+package logjam
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Transport is an http.RoundTripper for calling other services. It adds the logjam call
+// headers of the logjam request found in the context of outgoing requests, like
+// SetCallHeaders, and records the time spent waiting for responses as rest_time and the
+// number of calls as rest_calls on that logjam request. Create it using NewTransport.
+type Transport struct {
+	base    http.RoundTripper
+	metrics []hostMetric
+}
+
+// hostMetric maps a host pattern to a metric name.
+type hostMetric struct {
+	pattern string // the host, or the domain for wildcard patterns
+	suffix  bool   // whether the pattern matches subdomains
+	name    string // the metric name
+}
+
+// NewTransport creates a Transport sending requests using the given base transport, or
+// http.DefaultTransport if nil. Calls to hosts found in hostMetrics are recorded under the
+// mapped name instead of rest, e.g. {"api.stripe.com": "payment"} records payment_time and
+// payment_calls. Patterns starting with "*." match all subdomains of the domain, with exact
+// hosts taking precedence over wildcards and longer wildcards over shorter ones.
+func NewTransport(base http.RoundTripper, hostMetrics map[string]string) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &Transport{base: base}
+	for pattern, name := range hostMetrics {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			t.metrics = append(t.metrics, hostMetric{pattern: pattern[1:], suffix: true, name: name})
+		} else {
+			t.metrics = append(t.metrics, hostMetric{pattern: pattern, name: name})
+		}
+	}
+	sort.Slice(t.metrics, func(i, j int) bool {
+		a, b := t.metrics[i], t.metrics[j]
+		if a.suffix != b.suffix {
+			return !a.suffix
+		}
+		return len(a.pattern) > len(b.pattern)
+	})
+	return t
+}
+
+// metric returns the metric name for calls to the given host.
+func (t *Transport) metric(host string) string {
+	host = strings.ToLower(host)
+	for _, m := range t.metrics {
+		if m.suffix && strings.HasSuffix(host, m.pattern) || !m.suffix && host == m.pattern {
+			return m.name
+		}
+	}
+	return "rest"
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	request := GetRequest(r.Context())
+	if request == nil {
+		return t.base.RoundTrip(r)
+	}
+	// RoundTrippers must not modify the request.
+	outgoing := r.Clone(r.Context())
+	SetCallHeaders(r.Context(), outgoing)
+	metric := t.metric(r.URL.Hostname())
+	start := time.Now()
+	res, err := t.base.RoundTrip(outgoing)
+	request.AddDuration(metric+"_time", time.Since(start))
+	request.Count(metric + "_calls")
+	return res, err
+}
@@ -0,0 +1,67 @@
+package logjam
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recordingTransport struct {
+	requests []*http.Request
+}
+
+func (t *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, r)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+}
+
+func TestTransport(t *testing.T) {
+	Convey("mapping hosts to metrics", t, func() {
+		transport := NewTransport(nil, map[string]string{
+			"api.stripe.com":     "payment",
+			"*.search.internal":  "search",
+			"*.internal":         "internal",
+			"images.example.com": "images",
+		})
+		So(transport.metric("api.stripe.com"), ShouldEqual, "payment")
+		So(transport.metric("API.Stripe.com"), ShouldEqual, "payment")
+		So(transport.metric("stripe.com"), ShouldEqual, "rest")
+		So(transport.metric("eu.search.internal"), ShouldEqual, "search")
+		So(transport.metric("users.internal"), ShouldEqual, "internal")
+		So(transport.metric("internal"), ShouldEqual, "rest")
+		So(transport.metric("example.com"), ShouldEqual, "rest")
+	})
+
+	Convey("recording calls", t, func() {
+		agent := NewAgent(&Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+		base := &recordingTransport{}
+		client := &http.Client{Transport: NewTransport(base, map[string]string{"api.stripe.com": "payment"})}
+		request := agent.NewRequest("Orders#create")
+		ctx := request.NewContext(context.Background())
+
+		for _, url := range []string{"https://api.stripe.com/charges", "http://users.internal/users/1", "http://users.internal/users/2"} {
+			r := httptest.NewRequest("GET", url, nil).WithContext(ctx)
+			r.RequestURI = ""
+			res, err := client.Do(r)
+			So(err, ShouldBeNil)
+			res.Body.Close()
+			So(r.Header.Get("X-Logjam-Action"), ShouldEqual, "")
+		}
+		So(base.requests[0].Header.Get("X-Logjam-Action"), ShouldEqual, "Orders#create")
+		So(request.counts["payment_calls"], ShouldEqual, 1)
+		So(request.counts["rest_calls"], ShouldEqual, 2)
+		So(request.durations, ShouldContainKey, "payment_time")
+		So(request.durations, ShouldContainKey, "rest_time")
+
+		res, err := client.Get("http://users.internal/users/3")
+		So(err, ShouldBeNil)
+		res.Body.Close()
+		So(base.requests[3].Header.Get("X-Logjam-Action"), ShouldEqual, "")
+	})
+}
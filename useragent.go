@@ -0,0 +1,80 @@
+package logjam
+
+import (
+	"regexp"
+	"strings"
+)
+
+// User-Agent classes reported in the ua_class field.
+const (
+	uaBrowser   = "browser"
+	uaBot       = "bot"
+	uaAPIClient = "api-client"
+	uaOther     = "other"
+)
+
+var botUserAgent = regexp.MustCompile(`(?i)([\w-]*(?:bot|crawler|spider|slurp)\b)|(facebookexternalhit|headlesschrome|pingdom|uptimerobot)`)
+
+// apiClients maps product tokens of common HTTP libraries and command line tools to
+// names reported in the ua_name field.
+var apiClients = map[string]string{
+	"curl":              "curl",
+	"wget":              "Wget",
+	"go-http-client":    "Go",
+	"python-requests":   "python-requests",
+	"python-urllib":     "Python",
+	"aiohttp":           "aiohttp",
+	"okhttp":            "OkHttp",
+	"axios":             "axios",
+	"node-fetch":        "node-fetch",
+	"postmanruntime":    "Postman",
+	"apache-httpclient": "Apache HttpClient",
+	"java":              "Java",
+	"faraday":           "Faraday",
+	"ruby":              "Ruby",
+	"httpie":            "HTTPie",
+}
+
+// browsers lists product tokens identifying browsers. Order matters, as most browsers
+// also claim to be Chrome and/or Safari.
+var browsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"Edge/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"MSIE ", "Internet Explorer"},
+	{"Trident/", "Internet Explorer"},
+}
+
+// classifyUserAgent returns class (browser, bot, api-client or other) and name of the
+// client sending the given User-Agent. It relies on a few well known product tokens only,
+// so names of less common clients are not detected.
+func classifyUserAgent(userAgent string) (class string, name string) {
+	if matches := botUserAgent.FindStringSubmatch(userAgent); matches != nil {
+		if matches[1] != "" {
+			return uaBot, matches[1]
+		}
+		return uaBot, matches[2]
+	}
+	product := userAgent
+	if i := strings.IndexAny(product, "/ "); i >= 0 {
+		product = product[:i]
+	}
+	if name, found := apiClients[strings.ToLower(product)]; found {
+		return uaAPIClient, name
+	}
+	if strings.HasPrefix(userAgent, "Mozilla/") {
+		for _, browser := range browsers {
+			if strings.Contains(userAgent, browser.token) {
+				return uaBrowser, browser.name
+			}
+		}
+		return uaBrowser, ""
+	}
+	return uaOther, product
+}
@@ -0,0 +1,31 @@
+package logjam
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClassifyUserAgent(t *testing.T) {
+	Convey("User-Agent classification", t, func() {
+		agents := map[string][2]string{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":  {"bot", "Googlebot"},
+			"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)": {"bot", "facebookexternalhit"},
+			"curl/7.64.1":             {"api-client", "curl"},
+			"Go-http-client/1.1":      {"api-client", "Go"},
+			"python-requests/2.25.1":  {"api-client", "python-requests"},
+			"PostmanRuntime/7.26.8":   {"api-client", "Postman"},
+			"MyApp/1.0 (iPhone; iOS)": {"other", "MyApp"},
+			"Robotics/2.0":            {"other", "Robotics"},
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36":                 {"browser", "Chrome"},
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36 Edg/91.0.864.59": {"browser", "Edge"},
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.1 Safari/605.1.15":             {"browser", "Safari"},
+			"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:89.0) Gecko/20100101 Firefox/89.0":                                                        {"browser", "Firefox"},
+		}
+		for userAgent, expected := range agents {
+			class, name := classifyUserAgent(userAgent)
+			So(class, ShouldEqual, expected[0])
+			So(name, ShouldEqual, expected[1])
+		}
+	})
+}
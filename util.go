@@ -3,6 +3,7 @@ package logjam
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
 )
 
@@ -30,6 +31,25 @@ type Logger struct {
 	LogLevel    LogLevel // Log attemtps with a log level lower than this field are not forwarded to the embbeded logger.
 }
 
+// NewContext creates a new context with the logger added.
+func (l *Logger) NewContext(c context.Context) context.Context {
+	return context.WithValue(c, loggerKey, l)
+}
+
+// discardingLogger is returned by LoggerFromContext for contexts without a logger. It only
+// forwards log lines to the logjam request stored in the context.
+var discardingLogger = &Logger{Logger: log.New(ioutil.Discard, "", 0), LogLevel: FATAL + 1}
+
+// LoggerFromContext returns the logger stored in the given context, e.g. by the logjam
+// middleware if the Logger middleware option is set. If the context holds no logger, it
+// returns a logger which sends lines only to logjam.
+func LoggerFromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey).(*Logger); ok {
+		return l
+	}
+	return discardingLogger
+}
+
 func (l *Logger) logf(ctx context.Context, severity LogLevel, format string, args ...interface{}) {
 	line := fmt.Sprintf(format, args...)
 	if request := GetRequest(ctx); request != nil {
@@ -0,0 +1,102 @@
+package logjam
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxStackDumpBytes limits the size of the buffer used to dump the stacks of all
+// goroutines when looking for the stack of a stuck request.
+const maxStackDumpBytes = 64 * 1024 * 1024
+
+// stackDumpInterval is the minimum time between two stack dumps of an agent. Dumping the
+// stacks of all goroutines stops the world, which must not happen for every request when
+// many requests get stuck at once.
+const stackDumpInterval = time.Minute
+
+// watchdog logs the stack of the goroutine serving a request if the request takes longer
+// than a given threshold.
+type watchdog struct {
+	mutex   sync.Mutex
+	stopped bool
+	timer   *time.Timer
+}
+
+// startWatchdog starts a watchdog for the calling goroutine, which must be the goroutine
+// serving the given request.
+func startWatchdog(r *Request, threshold time.Duration) *watchdog {
+	w := &watchdog{}
+	id := goroutineID()
+	w.timer = time.AfterFunc(threshold, func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if w.stopped {
+			return
+		}
+		if !r.agent.allowStackDump() {
+			r.Log(ERROR, fmt.Sprintf("request still running after %s (stack dumped less than %s ago)", threshold, stackDumpInterval))
+			return
+		}
+		if stack := goroutineStack(id); stack != nil {
+			r.Log(ERROR, fmt.Sprintf("request still running after %s:\n%s", threshold, stack))
+		}
+	})
+	return w
+}
+
+// stop stops the watchdog. The request is not accessed by the watchdog once stop returns.
+func (w *watchdog) stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.stopped = true
+	w.timer.Stop()
+}
+
+// allowStackDump returns whether a stack dump may be taken now, which is the case if the
+// last one was taken at least stackDumpInterval ago.
+func (a *Agent) allowStackDump() bool {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&a.lastStackDump)
+	if last != 0 && now-last < int64(stackDumpInterval) {
+		return false
+	}
+	return atomic.CompareAndSwapInt64(&a.lastStackDump, last, now)
+}
+
+// goroutineID returns the id of the calling goroutine, parsed from the first line of its
+// stack trace ("goroutine 42 [running]:").
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = bytes.TrimPrefix(buf[:runtime.Stack(buf, false)], []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		id, _ := strconv.ParseUint(string(buf[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
+
+// goroutineStack returns the stack trace of the goroutine with the given id, or nil if
+// there is no such goroutine.
+func goroutineStack(id uint64) []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackDumpBytes {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	prefix := []byte("goroutine " + strconv.FormatUint(id, 10) + " ")
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, prefix) {
+			return stack
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/xing/logjam-agent-go"
+)
+
+// Conventional keys for the metrics recorded on WebSocket connections.
+const (
+	MessagesReceived = "websocket_messages_received" // Number of messages read from the connection.
+	MessagesSent     = "websocket_messages_sent"     // Number of messages written to the connection.
+	BytesReceived    = "websocket_received_bytes"    // Size of messages read from the connection.
+	BytesSent        = "websocket_sent_bytes"        // Size of messages written to the connection.
+)
+
+// Conn is a WebSocket connection recording the messages read and written as metrics on
+// the logjam request of the upgraded http request.
+type Conn struct {
+	*websocket.Conn
+	request *logjam.Request
+}
+
+// Upgrade upgrades the connection of a request handled by the logjam middleware to the
+// WebSocket protocol. The middleware finishes the logjam request when the connection
+// gets closed, reporting the time until the upgrade as total_time and the lifetime of the
+// connection as streaming_duration. Messages read and written using the returned
+// connection are counted on the logjam request.
+func Upgrade(upgrader *websocket.Upgrader, w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		return nil, err
+	}
+	request := logjam.GetRequest(r.Context())
+	if request != nil {
+		request.SetField("websocket", true)
+	}
+	return &Conn{Conn: conn, request: request}, nil
+}
+
+// ReadMessage reads the next message from the connection.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	messageType, p, err := c.Conn.ReadMessage()
+	if err == nil {
+		c.received(len(p))
+	}
+	return messageType, p, err
+}
+
+// WriteMessage writes a message to the connection.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	err := c.Conn.WriteMessage(messageType, data)
+	if err == nil && messageType != websocket.CloseMessage {
+		c.sent(len(data))
+	}
+	return err
+}
+
+// ReadJSON reads the next message from the connection and decodes it into v.
+func (c *Conn) ReadJSON(v interface{}) error {
+	_, p, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+// WriteJSON writes the JSON encoding of v as a message to the connection.
+func (c *Conn) WriteJSON(v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(websocket.TextMessage, p)
+}
+
+func (c *Conn) received(n int) {
+	if c.request != nil {
+		c.request.Count(MessagesReceived)
+		c.request.AddBytes(BytesReceived, int64(n))
+	}
+}
+
+func (c *Conn) sent(n int) {
+	if c.request != nil {
+		c.request.Count(MessagesSent)
+		c.request.AddBytes(BytesSent, int64(n))
+	}
+}
@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+)
+
+func TestUpgrade(t *testing.T) {
+	Convey("counting messages on upgraded connections", t, func() {
+		agent := logjam.NewAgent(&logjam.Options{Logger: log.New(ioutil.Discard, "", 0)})
+		defer agent.Shutdown()
+
+		upgrader := &websocket.Upgrader{}
+		echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := Upgrade(upgrader, w, r, nil)
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var message map[string]string
+				for conn.ReadJSON(&message) == nil {
+					conn.WriteJSON(message)
+				}
+			}()
+		})
+
+		type result struct {
+			request *logjam.Request
+			code    int
+		}
+		finished := make(chan result, 1)
+		handler := agent.NewHandler(echo, logjam.MiddlewareOptions{
+			BeforeFinish: func(r *http.Request, req *logjam.Request, code int) {
+				finished <- result{req, code}
+				req.Ignore()
+			},
+		})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		So(err, ShouldBeNil)
+		So(conn.WriteMessage(websocket.TextMessage, []byte(`{"hello":"world"}`)), ShouldBeNil)
+		_, p, err := conn.ReadMessage()
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, `{"hello":"world"}`)
+		conn.Close()
+
+		res := <-finished
+		So(res.code, ShouldEqual, http.StatusSwitchingProtocols)
+		So(res.request.GetField("websocket"), ShouldEqual, true)
+	})
+}
@@ -0,0 +1,134 @@
+package zap
+
+import (
+	"context"
+	"strings"
+
+	"github.com/xing/logjam-agent-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RequestKey is the key of the field created by Request.
+const RequestKey = "logjam_request"
+
+// Request returns a field carrying the logjam request found in the given context. Entries
+// logged with this field, or by a logger derived from it using With, are added as log lines
+// to the request by Core. Other cores ignore the field.
+func Request(ctx context.Context) zap.Field {
+	return zap.Field{Key: RequestKey, Type: zapcore.SkipType, Interface: logjam.GetRequest(ctx)}
+}
+
+// Logger returns a logger which adds all entries to the logjam request found in the given
+// context. It's a shortcut for logger.With(Request(ctx)).
+func Logger(logger *zap.Logger, ctx context.Context) *zap.Logger {
+	return logger.With(Request(ctx))
+}
+
+// Core implements zapcore.Core. It adds entries logged with the field returned by Request
+// to the logjam request carried by the field and optionally passes them on to a wrapped
+// core. The fields of an entry are appended to the message in JSON format. Levels DPanic,
+// Panic and Fatal are mapped to FATAL. Only entries enabled by the level of the core are
+// added to logjam requests, which additionally drop lines below the log level of the agent.
+type Core struct {
+	level   zapcore.LevelEnabler
+	next    zapcore.Core    // The wrapped core, may be nil.
+	enc     zapcore.Encoder // Encodes the fields added by With and those of each entry.
+	request *logjam.Request // The request added by With, if any.
+}
+
+// NewCore creates a core which adds entries enabled by the given level to logjam requests
+// and passes them on to the given core, unless it is nil. Install it with
+// zap.New(logjamzap.NewCore(zapcore.DebugLevel, core)).
+func NewCore(level zapcore.LevelEnabler, next zapcore.Core) *Core {
+	return &Core{level: level, next: next, enc: zapcore.NewJSONEncoder(zapcore.EncoderConfig{})}
+}
+
+// Enabled returns true if the level is enabled by the core or by the wrapped core.
+func (c *Core) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level) || (c.next != nil && c.next.Enabled(level))
+}
+
+// With returns a core which adds the given fields to all entries.
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for _, field := range fields {
+		if request, ok := requestFrom(field); ok {
+			clone.request = request
+		} else {
+			field.AddTo(clone.enc)
+		}
+	}
+	if c.next != nil {
+		clone.next = c.next.With(fields)
+	}
+	return &clone
+}
+
+// Check adds the core and the wrapped core to the checked entry, if they are enabled.
+func (c *Core) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.level.Enabled(entry.Level) {
+		checked = checked.AddCore(entry, c)
+	}
+	if c.next != nil {
+		checked = c.next.Check(entry, checked)
+	}
+	return checked
+}
+
+// Write adds the entry as log line to the logjam request, if any. Writing to the wrapped
+// core is left to the checked entry.
+func (c *Core) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	request := c.request
+	for _, field := range fields {
+		if r, ok := requestFrom(field); ok {
+			request = r
+		}
+	}
+	if request == nil {
+		return nil
+	}
+	buffer, err := c.enc.EncodeEntry(zapcore.Entry{}, fields)
+	if err != nil {
+		return err
+	}
+	line := entry.Message
+	if encoded := strings.TrimSpace(buffer.String()); encoded != "{}" {
+		line += " " + encoded
+	}
+	buffer.Free()
+	request.Log(severity(entry.Level), line)
+	return nil
+}
+
+// Sync flushes the wrapped core.
+func (c *Core) Sync() error {
+	if c.next != nil {
+		return c.next.Sync()
+	}
+	return nil
+}
+
+func requestFrom(field zapcore.Field) (*logjam.Request, bool) {
+	if field.Type != zapcore.SkipType || field.Key != RequestKey {
+		return nil, false
+	}
+	request, _ := field.Interface.(*logjam.Request)
+	return request, true
+}
+
+func severity(level zapcore.Level) logjam.LogLevel {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return logjam.DEBUG
+	case level == zapcore.InfoLevel:
+		return logjam.INFO
+	case level == zapcore.WarnLevel:
+		return logjam.WARN
+	case level == zapcore.ErrorLevel:
+		return logjam.ERROR
+	default:
+		return logjam.FATAL
+	}
+}
@@ -0,0 +1,70 @@
+package zap
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/xing/logjam-agent-go"
+	"github.com/xing/logjam-agent-go/internal/logjamtest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCore(t *testing.T) {
+	collector := logjamtest.NewCollector("zap-test", logjam.Options{})
+	defer collector.Close()
+	agent := collector.Agent
+
+	Convey("adding entries to the logjam request", t, func() {
+		var buffer bytes.Buffer
+		wrapped := zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"}), zapcore.AddSync(&buffer), zapcore.WarnLevel)
+		logger := zap.New(NewCore(zapcore.DebugLevel, wrapped)).With(zap.String("app", "users"))
+
+		request := agent.NewRequest("Users#show")
+		ctx := request.NewContext(context.Background())
+		Logger(logger, ctx).Debug("looking up", zap.Int("id", 42))
+		logger.Warn("not found", Request(ctx), zap.String("name", "John"))
+		logger.Error("without request")
+		Logger(logger, ctx).DPanic("giving up")
+		request.Finish(404)
+
+		output := collector.Receive()
+		So(output["severity"], ShouldEqual, float64(logjam.FATAL))
+		lines := output["lines"].([]interface{})
+		So(lines, ShouldHaveLength, 3)
+		So(lines[0].([]interface{})[0], ShouldEqual, float64(logjam.DEBUG))
+		So(lines[0].([]interface{})[2], ShouldEqual, `looking up {"app":"users","id":42}`)
+		So(lines[1].([]interface{})[0], ShouldEqual, float64(logjam.WARN))
+		So(lines[1].([]interface{})[2], ShouldEqual, `not found {"app":"users","name":"John"}`)
+		So(lines[2].([]interface{})[0], ShouldEqual, float64(logjam.FATAL))
+		So(lines[2].([]interface{})[2], ShouldEqual, `giving up {"app":"users"}`)
+
+		So(buffer.String(), ShouldContainSubstring, `{"msg":"without request","app":"users"}`)
+		So(buffer.String(), ShouldNotContainSubstring, "looking up")
+	})
+
+	Convey("honoring the level of the core", t, func() {
+		var buffer bytes.Buffer
+		wrapped := zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{}), zapcore.AddSync(&buffer), zapcore.WarnLevel)
+		core := NewCore(zapcore.InfoLevel, wrapped)
+		So(core.Enabled(zapcore.DebugLevel), ShouldBeFalse)
+		So(core.Enabled(zapcore.InfoLevel), ShouldBeTrue)
+		So(NewCore(zapcore.ErrorLevel, nil).Enabled(zapcore.WarnLevel), ShouldBeFalse)
+		So(NewCore(zapcore.ErrorLevel, wrapped).Enabled(zapcore.WarnLevel), ShouldBeTrue)
+
+		request := agent.NewRequest("Users#index")
+		ctx := request.NewContext(context.Background())
+		logger := Logger(zap.New(core), ctx)
+		logger.Debug("dropped")
+		logger.Info("listing users")
+		request.Finish(200)
+
+		output := collector.Receive()
+		lines := output["lines"].([]interface{})
+		So(lines, ShouldHaveLength, 1)
+		So(lines[0].([]interface{})[2], ShouldEqual, "listing users")
+		So(buffer.String(), ShouldBeEmpty)
+	})
+}